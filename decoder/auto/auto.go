@@ -0,0 +1,157 @@
+package auto
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+
+	"github.com/juju/errors"
+	"github.com/monsterxx03/pipe/decoder"
+	"github.com/monsterxx03/pipe/decoder/redis"
+)
+
+var httpPrefixes = [][]byte{
+	[]byte("GET "), []byte("POST "), []byte("PUT "), []byte("DELETE "), []byte("HEAD "),
+	[]byte("OPTIONS "), []byte("PATCH "), []byte("CONNECT "), []byte("TRACE "), []byte("HTTP/"),
+}
+
+// Decoder sniffs first bytes of stream and dispatches it to registered
+// decoder of detected protocol, fallback decoder is used if nothing matches.
+type Decoder struct {
+	filter   string
+	fallback string
+	// options of detected decoders
+	args map[string]string
+}
+
+func (d *Decoder) Decode(reader io.Reader, writer decoder.MessageWriter, opts *decoder.Options) error {
+	br := bufio.NewReader(reader)
+	// wait for first data, then sniff what's buffered
+	if _, err := br.Peek(1); err != nil {
+		return err
+	}
+	data, _ := br.Peek(br.Buffered())
+	name := detect(data)
+	if name == "text" {
+		name = d.fallback
+	}
+	if _, err := decoder.GetDecoder(name); err != nil {
+		// known protocol without decoder, eg: tls, tell it and skip stream
+		msg := &decoder.Message{Protocol: name, Text: name + " stream, no decoder for it\n"}
+		return writer.WriteMessage(msg)
+	}
+	dec, err := decoder.NewDecoder(name, decoder.SubArgs(name, d.args))
+	if err != nil {
+		return err
+	}
+	dec.SetFilter(d.filter)
+	return dec.Decode(br, writer, opts)
+}
+
+func (d *Decoder) SetFilter(filter string) {
+	d.filter = filter
+}
+
+// detect returns protocol name of data
+func detect(data []byte) string {
+	for _, prefix := range httpPrefixes {
+		if bytes.HasPrefix(data, prefix) {
+			return "http"
+		}
+	}
+	if isTLS(data) {
+		return "tls"
+	}
+	if isMySQL(data) {
+		return "mysql"
+	}
+	if len(data) > 1 && redis.IsRespType(data[0]) && bytes.Contains(data, []byte("\r\n")) || isInlineCommand(data) {
+		return "redis"
+	}
+	return "text"
+}
+
+// isInlineCommand checks redis inline command without args, which is an
+// uppercase word in a line, eg: PING\r\n
+func isInlineCommand(data []byte) bool {
+	end := bytes.Index(data, []byte("\r\n"))
+	if end <= 0 {
+		return false
+	}
+	for _, b := range data[:end] {
+		if b < 'A' || b > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// tls record: handshake type 0x16, version 0x03xx, then ClientHello(1) or
+// ServerHello(2)
+func isTLS(data []byte) bool {
+	return len(data) > 5 && data[0] == 0x16 && data[1] == 0x03 && data[2] <= 0x04 &&
+		(data[5] == 0x01 || data[5] == 0x02)
+}
+
+// mysql packet: 3 bytes payload length, 1 byte sequence id. Server sends
+// handshake with protocol version 10 first, client replies with sequence 1.
+func isMySQL(data []byte) bool {
+	if len(data) < 5 {
+		return false
+	}
+	length := int(data[0]) | int(data[1])<<8 | int(data[2])<<16
+	if length != len(data)-4 {
+		return false
+	}
+	return (data[3] == 0 && data[4] == 0x0a) || data[3] == 1
+}
+
+// checkArgs checks every option is supported by some decoder, and its value
+// is accepted by them.
+func checkArgs(args map[string]string) error {
+	known := make(map[string]bool)
+	for _, info := range decoder.List() {
+		if info.PassArgs {
+			continue
+		}
+		sub := decoder.SubArgs(info.Name, args)
+		if len(sub) == 0 {
+			continue
+		}
+		if _, err := decoder.NewDecoder(info.Name, sub); err != nil {
+			return err
+		}
+		for k := range sub {
+			known[k] = true
+		}
+	}
+	for k := range args {
+		if !known[k] {
+			return errors.Errorf("unknown option %q for decoder auto", k)
+		}
+	}
+	return nil
+}
+
+func init() {
+	decoder.Register(&decoder.Info{
+		Name:        "auto",
+		Description: "detect protocol by first bytes of every stream: http, redis, tls, mysql, or fallback",
+		Args:        map[string]string{"fallback": "decoder used if no protocol detected, default text, other options are passed to detected decoder"},
+		PassArgs:    true,
+		New: func(args map[string]string) (decoder.Decoder, error) {
+			d := &Decoder{fallback: "text", args: make(map[string]string)}
+			for k, v := range args {
+				if k == "fallback" {
+					if _, err := decoder.GetDecoder(v); err != nil {
+						return nil, err
+					}
+					d.fallback = v
+				} else {
+					d.args[k] = v
+				}
+			}
+			return d, checkArgs(d.args)
+		},
+	})
+}
@@ -0,0 +1,78 @@
+package auto
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/monsterxx03/pipe/decoder"
+	_ "github.com/monsterxx03/pipe/decoder/http"
+	_ "github.com/monsterxx03/pipe/decoder/redis"
+	_ "github.com/monsterxx03/pipe/decoder/text"
+)
+
+type msgCollector struct {
+	msgs []*decoder.Message
+}
+
+func (c *msgCollector) WriteMessage(msg *decoder.Message) error {
+	c.msgs = append(c.msgs, msg)
+	return nil
+}
+
+func TestDetect(t *testing.T) {
+	cases := map[string]string{
+		"GET / HTTP/1.1\r\n":           "http",
+		"HTTP/1.1 200 OK\r\n":          "http",
+		"*1\r\n$4\r\nping\r\n":         "redis",
+		"+OK\r\n":                      "redis",
+		"%1\r\n+a\r\n:1\r\n":           "redis",
+		"PING\r\n":                     "redis",
+		"Ping\r\n":                     "text",
+		"\x16\x03\x01\x00\xa5\x01\x00": "tls",
+		"\x05\x00\x00\x00\x0a5.7\x00":  "mysql",
+		"hello world":                  "text",
+		"-not redis without line end":  "text",
+	}
+	for data, expected := range cases {
+		if result := detect([]byte(data)); result != expected {
+			t.Errorf("%q detected as %s, expected %s", data, result, expected)
+		}
+	}
+}
+
+func TestAutoDecode(t *testing.T) {
+	cases := map[string]string{
+		"*2\r\n$3\r\nget\r\n$1\r\na\r\n":           "redis",
+		"PING\r\n":                                 "redis",
+		"GET /a HTTP/1.1\r\nHost: b\r\n\r\n":       "http",
+		"\x16\x03\x01\x00\xa5\x01\x00\x00\xa1\x03": "tls",
+		"hello": "text",
+	}
+	for data, protocol := range cases {
+		c := &msgCollector{}
+		dec, _ := decoder.NewDecoder("auto", nil)
+		dec.Decode(bytes.NewReader([]byte(data)), c, &decoder.Options{IsRequest: true})
+		if len(c.msgs) != 1 || c.msgs[0].Protocol != protocol {
+			t.Errorf("%q decoded as %v, expected %s", data, c.msgs, protocol)
+		}
+	}
+}
+
+func TestAutoArgs(t *testing.T) {
+	// options are passed to detected decoder
+	dec, err := decoder.NewDecoder("auto", map[string]string{"fallback": "text", "size": "2", "slots": "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &msgCollector{}
+	dec.Decode(bytes.NewReader([]byte("hello")), c, &decoder.Options{IsRequest: true})
+	if len(c.msgs) != 3 || c.msgs[0].Body != "he" {
+		t.Errorf("size not passed to text decoder: %v", c.msgs)
+	}
+
+	for _, args := range []map[string]string{{"foo": "1"}, {"size": "x"}} {
+		if _, err := decoder.NewDecoder("auto", args); err == nil {
+			t.Errorf("expect error for %v", args)
+		}
+	}
+}
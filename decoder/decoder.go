@@ -1,30 +1,106 @@
 package decoder
 
 import (
-	"github.com/juju/errors"
 	"io"
+	"sort"
+	"strings"
+
+	"github.com/juju/errors"
 )
 
-var DECODERS = map[string]Decoder{}
+var DECODERS = map[string]*Info{}
 
 type Options struct {
 	DeepDecode bool
+	// IsRequest is true if the stream is from client to server
+	IsRequest bool
 }
 
 type Decoder interface {
-	Decode(io.Reader, io.Writer, *Options) error
+	Decode(io.Reader, MessageWriter, *Options) error
 	SetFilter(string)
 }
 
-func Register(name string, dec Decoder) {
-	if _, ok := DECODERS[name]; !ok {
-		DECODERS[name] = dec
+// Constructor creates a decoder for one tcp stream, so every stream keeps
+// its own decoding state. args are decoder specific options.
+type Constructor func(args map[string]string) (Decoder, error)
+
+// Info describes a registered decoder.
+type Info struct {
+	Name        string
+	Description string
+	// Args are supported options: name -> description
+	Args map[string]string
+	// PassArgs is true if decoder passes unknown options to decoders it
+	// creates, it checks them itself, eg: auto
+	PassArgs bool
+	New      Constructor
+}
+
+func Register(info *Info) {
+	if _, ok := DECODERS[info.Name]; !ok {
+		DECODERS[info.Name] = info
 	}
 }
 
-func GetDecoder(name string) (Decoder, error) {
-	if dec, ok := DECODERS[name]; ok {
-		return dec, nil
+func GetDecoder(name string) (*Info, error) {
+	if info, ok := DECODERS[name]; ok {
+		return info, nil
 	}
 	return nil, errors.New("Decoder not found: " + name)
 }
+
+// NewDecoder returns a new instance of the registered decoder.
+func NewDecoder(name string, args map[string]string) (Decoder, error) {
+	info, err := GetDecoder(name)
+	if err != nil {
+		return nil, err
+	}
+	for k := range args {
+		if _, ok := info.Args[k]; !ok && !info.PassArgs {
+			return nil, errors.Errorf("unknown option %q for decoder %s", k, name)
+		}
+	}
+	return info.New(args)
+}
+
+// SubArgs returns options in args supported by decoder name.
+func SubArgs(name string, args map[string]string) map[string]string {
+	info, err := GetDecoder(name)
+	if err != nil {
+		return nil
+	}
+	result := make(map[string]string)
+	for k, v := range args {
+		if _, ok := info.Args[k]; ok {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// List returns registered decoders sorted by name.
+func List() []*Info {
+	result := make([]*Info, 0, len(DECODERS))
+	for _, info := range DECODERS {
+		result = append(result, info)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// ParseArgs parses decoder options, eg: "a=1,b=2"
+func ParseArgs(s string) (map[string]string, error) {
+	args := make(map[string]string)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.Errorf("bad decoder option %q, should be key=value", item)
+		}
+		args[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return args, nil
+}
@@ -0,0 +1,56 @@
+package decoder
+
+import (
+	"io"
+	"testing"
+)
+
+type testDecoder struct {
+	name string
+}
+
+func (d *testDecoder) Decode(io.Reader, MessageWriter, *Options) error { return nil }
+func (d *testDecoder) SetFilter(string)                                {}
+
+func TestRegistry(t *testing.T) {
+	defer delete(DECODERS, "test")
+	Register(&Info{
+		Name:        "test",
+		Description: "decoder for test",
+		Args:        map[string]string{"name": "name of decoder"},
+		New: func(args map[string]string) (Decoder, error) {
+			return &testDecoder{name: args["name"]}, nil
+		},
+	})
+	d1, err := NewDecoder("test", map[string]string{"name": "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d2, _ := NewDecoder("test", nil)
+	if d1 == d2 || d1.(*testDecoder).name != "a" {
+		t.Error("expect new decoder instance with options")
+	}
+	if _, err := NewDecoder("test", map[string]string{"size": "1"}); err == nil {
+		t.Error("expect error for unknown option")
+	}
+	if _, err := NewDecoder("missing", nil); err == nil {
+		t.Error("expect error for unknown decoder")
+	}
+	found := false
+	for _, info := range List() {
+		found = found || info.Name == "test"
+	}
+	if !found {
+		t.Error("test decoder not listed")
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	args, err := ParseArgs("a=1, b = x=y")
+	if err != nil || len(args) != 2 || args["a"] != "1" || args["b"] != "x=y" {
+		t.Error("bad args:", args, err)
+	}
+	if _, err := ParseArgs("a"); err == nil {
+		t.Error("expect error for option without value")
+	}
+}
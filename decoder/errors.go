@@ -0,0 +1,54 @@
+package decoder
+
+import (
+	"io"
+	"log"
+
+	"github.com/juju/errors"
+)
+
+// ErrTruncated means stream ends in the middle of a msg.
+var ErrTruncated = errors.New("truncated msg")
+
+// MalformedError means data violates protocol, eg: capture starts in the
+// middle of a msg. Decoder reports it and skips to next msg boundary.
+type MalformedError struct {
+	Reason string
+}
+
+func (e *MalformedError) Error() string {
+	return "malformed msg: " + e.Reason
+}
+
+func Malformed(reason string) error {
+	return &MalformedError{Reason: reason}
+}
+
+func IsMalformed(err error) bool {
+	_, ok := errors.Cause(err).(*MalformedError)
+	return ok
+}
+
+// Truncated converts EOF met in the middle of a msg to ErrTruncated.
+func Truncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncated
+	}
+	return err
+}
+
+// ErrorReporter is implemented by MessageWriter which counts decode errors
+// of its stream.
+type ErrorReporter interface {
+	ReportError(err error)
+}
+
+// ReportError reports a recoverable decode error to writer, or logs it if
+// writer doesn't count errors.
+func ReportError(writer MessageWriter, err error) {
+	if r, ok := writer.(ErrorReporter); ok {
+		r.ReportError(err)
+		return
+	}
+	log.Println(err)
+}
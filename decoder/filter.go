@@ -0,0 +1,21 @@
+package decoder
+
+import "regexp"
+
+// ParseFilter parses filter string into field patterns, patterns are case
+// insensitive golang regexp.
+// eg: url: /test & method: get => {"url": /test, "method": get}
+func ParseFilter(filterStr string) map[string]*regexp.Regexp {
+	result := make(map[string]*regexp.Regexp)
+	if len(filterStr) == 0 {
+		return result
+	}
+	pattern := regexp.MustCompile("\\s+&\\s+")
+	filters := pattern.Split(filterStr, -1)
+	subPattern := regexp.MustCompile("\\s*:\\s*")
+	for _, filter := range filters {
+		r := subPattern.Split(filter, 2)
+		result[r[0]] = regexp.MustCompile("(?im:" + r[1] + ")")
+	}
+	return result
+}
@@ -0,0 +1,205 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/juju/errors"
+	"github.com/ugorji/go/codec"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// BodyDecoder decodes body of a media type into readable string, params are
+// parameters of content type, eg: charset, boundary.
+type BodyDecoder func(data []byte, params map[string]string) (string, error)
+
+var BODY_DECODERS = map[string]BodyDecoder{}
+
+// RegisterBody registers body decoder for media type, eg: application/json,
+// decoder registered before for the same type is replaced.
+func RegisterBody(mediaType string, dec BodyDecoder) {
+	BODY_DECODERS[strings.ToLower(mediaType)] = dec
+}
+
+// GetBodyDecoder finds decoder for media type, structured syntax suffix is
+// tried if there's no decoder for the type, eg: application/vnd.api+json is
+// decoded as application/json.
+func GetBodyDecoder(mediaType string) (BodyDecoder, bool) {
+	mediaType = strings.ToLower(mediaType)
+	if dec, ok := BODY_DECODERS[mediaType]; ok {
+		return dec, true
+	}
+	if i := strings.LastIndex(mediaType, "+"); i != -1 {
+		dec, ok := BODY_DECODERS["application/"+mediaType[i+1:]]
+		return dec, ok
+	}
+	return nil, false
+}
+
+func decodeToString(contentType string, data []byte) (string, error) {
+	if len(data) == 0 {
+		return "", nil
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return string(data), nil
+	}
+	if dec, ok := GetBodyDecoder(mediaType); ok {
+		return dec(data, params)
+	}
+	return string(data), nil
+}
+
+func decodeJSON(data []byte, params map[string]string) (string, error) {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, "", " "); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func decodeForm(data []byte, params map[string]string) (string, error) {
+	values, err := url.ParseQuery(string(data))
+	if err != nil {
+		return "", err
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	result := ""
+	for _, k := range keys {
+		for _, v := range values[k] {
+			result += k + ": " + v + "\n"
+		}
+	}
+	return result, nil
+}
+
+// max size of multipart field value to be shown
+const maxPartValue = 256
+
+// decodeMultipart summarises every part, only small text field values are
+// shown.
+func decodeMultipart(data []byte, params map[string]string) (string, error) {
+	boundary, ok := params["boundary"]
+	if !ok {
+		return "", errors.New("multipart boundary not found")
+	}
+	r := multipart.NewReader(bytes.NewReader(data), boundary)
+	result := ""
+	for {
+		part, err := r.NextPart()
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		value, err := ioutil.ReadAll(part)
+		if err != nil {
+			return "", err
+		}
+		result += fmt.Sprintf("part: name=%q", part.FormName())
+		if part.FileName() != "" {
+			result += fmt.Sprintf(" filename=%q", part.FileName())
+		}
+		if ct := part.Header.Get("Content-Type"); ct != "" {
+			result += " content-type=" + ct
+		}
+		result += fmt.Sprintf(" size=%d\n", len(value))
+		if part.FileName() == "" && len(value) <= maxPartValue && utf8.Valid(value) {
+			result += string(value) + "\n"
+		}
+	}
+}
+
+func decodeXML(data []byte, params map[string]string) (string, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	// don't convert charset, only indent
+	dec.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	var buf bytes.Buffer
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", " ")
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if data, ok := token.(xml.CharData); ok {
+			if data = bytes.TrimSpace(data); len(data) == 0 {
+				continue
+			}
+			token = data
+		}
+		if err := enc.EncodeToken(xml.CopyToken(token)); err != nil {
+			return "", err
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+var ch codec.CborHandle
+
+func decodeCodec(h codec.Handle) BodyDecoder {
+	return func(data []byte, params map[string]string) (string, error) {
+		var v interface{}
+		if err := codec.NewDecoder(bytes.NewReader(data), h).Decode(&v); err != nil {
+			return "", err
+		}
+		pretty, err := prettyValue(v)
+		if err != nil {
+			return "", err
+		}
+		pv, err := json.MarshalIndent(pretty, "", " ")
+		if err != nil {
+			return "", err
+		}
+		return string(pv), nil
+	}
+}
+
+func decodeBSON(data []byte, params map[string]string) (string, error) {
+	var v bson.M
+	if err := bson.Unmarshal(data, &v); err != nil {
+		return "", err
+	}
+	pv, err := json.MarshalIndent(v, "", " ")
+	if err != nil {
+		return "", err
+	}
+	return string(pv), nil
+}
+
+func init() {
+	RegisterBody("application/json", decodeJSON)
+	RegisterBody("application/x-www-form-urlencoded", decodeForm)
+	RegisterBody("multipart/form-data", decodeMultipart)
+	RegisterBody("application/xml", decodeXML)
+	RegisterBody("text/xml", decodeXML)
+	RegisterBody("application/msgpack", decodeCodec(&mh))
+	RegisterBody("application/x-msgpack", decodeCodec(&mh))
+	RegisterBody("application/cbor", decodeCodec(&ch))
+	RegisterBody("application/bson", decodeBSON)
+	RegisterBody("application/protobuf", decodeProtobuf)
+	RegisterBody("application/x-protobuf", decodeProtobuf)
+}
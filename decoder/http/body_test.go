@@ -0,0 +1,125 @@
+package http
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ugorji/go/codec"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func checkBody(t *testing.T, contentType string, data []byte, expected string) {
+	result, err := decodeToString(contentType, data)
+	if err != nil {
+		t.Fatal(contentType, err)
+	}
+	if result != expected {
+		t.Errorf("%s result: %q \n no match expected: %q", contentType, result, expected)
+	}
+}
+
+func TestDecodeBodyJSON(t *testing.T) {
+	data := []byte(`{"a":1,"b":[true]}`)
+	expected := "{\n \"a\": 1,\n \"b\": [\n  true\n ]\n}"
+	checkBody(t, "application/json; charset=utf-8", data, expected)
+	checkBody(t, "application/vnd.api+json", data, expected)
+	// unknown type is shown as it is
+	checkBody(t, "application/octet-stream", data, string(data))
+}
+
+func TestDecodeBodyForm(t *testing.T) {
+	checkBody(t, "application/x-www-form-urlencoded", []byte("b=2&a=hello+world&b=3"),
+		"a: hello world\nb: 2\nb: 3\n")
+}
+
+func TestDecodeBodyMultipart(t *testing.T) {
+	data := "--XX\r\nContent-Disposition: form-data; name=\"title\"\r\n\r\nhello\r\n" +
+		"--XX\r\nContent-Disposition: form-data; name=\"file\"; filename=\"a.png\"\r\nContent-Type: image/png\r\n\r\n\x89PNG\r\n" +
+		"--XX--\r\n"
+	checkBody(t, "multipart/form-data; boundary=XX", []byte(data),
+		"part: name=\"title\" size=5\nhello\npart: name=\"file\" filename=\"a.png\" content-type=image/png size=4\n")
+}
+
+func TestDecodeBodyXML(t *testing.T) {
+	checkBody(t, "text/xml", []byte("<a><b id=\"1\">hi</b>\n  <c/></a>"),
+		"<a>\n <b id=\"1\">hi</b>\n <c></c>\n</a>")
+}
+
+func TestDecodeBodyCBORAndBSON(t *testing.T) {
+	var buf bytes.Buffer
+	codec.NewEncoder(&buf, &ch).Encode(map[string]interface{}{"a": 1})
+	checkBody(t, "application/cbor", buf.Bytes(), "{\n \"a\": 1\n}")
+
+	// non-string keys and top level array
+	buf.Reset()
+	codec.NewEncoder(&buf, &ch).Encode([]interface{}{map[uint64]interface{}{1: map[uint64]string{2: "b"}}})
+	checkBody(t, "application/cbor", buf.Bytes(), "[\n {\n  \"1\": {\n   \"2\": \"b\"\n  }\n }\n]")
+
+	data, err := bson.Marshal(bson.M{"a": "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBody(t, "application/bson", data, "{\n \"a\": \"b\"\n}")
+}
+
+func TestDecodeBodyProtobufRaw(t *testing.T) {
+	var nested []byte
+	nested = protowire.AppendTag(nested, 1, protowire.VarintType)
+	nested = protowire.AppendVarint(nested, 150)
+	var data []byte
+	data = protowire.AppendTag(data, 1, protowire.BytesType)
+	data = protowire.AppendString(data, "hello")
+	data = protowire.AppendTag(data, 2, protowire.BytesType)
+	data = protowire.AppendBytes(data, nested)
+	checkBody(t, "application/x-protobuf", data, "1: \"hello\"\n2: {\n 1: 150\n}\n")
+}
+
+func TestDecodeBodyProtobufDescriptor(t *testing.T) {
+	defer func() { protoFiles, protoType = nil, "" }()
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name:    proto.String("test.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("User"),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:     proto.String("name"),
+				JsonName: proto.String("name"),
+				Number:   proto.Int32(1),
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			}},
+		}},
+	}}}
+	desc, _ := proto.Marshal(set)
+	f, err := ioutil.TempFile("", "pipe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Write(desc)
+	f.Close()
+
+	if err := LoadProtoDescriptor(f.Name(), "test.Missing"); err == nil {
+		t.Error("expect error for unknown message")
+	}
+	if err := LoadProtoDescriptor(f.Name(), ""); err != nil {
+		t.Fatal(err)
+	}
+	var data []byte
+	data = protowire.AppendTag(data, 1, protowire.BytesType)
+	data = protowire.AppendString(data, "bob")
+	result, err := decodeToString("application/x-protobuf; messageType=test.User", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// protojson output has random spaces, compare without them
+	assertEqual(t, string(bytes.Join(bytes.Fields([]byte(result)), nil)), `{"name":"bob"}`)
+	// no message type, decode raw
+	checkBody(t, "application/x-protobuf", data, "1: \"bob\"\n")
+}
@@ -0,0 +1,268 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/monsterxx03/pipe/decoder"
+)
+
+// headers not forwarded to candidate
+var hopHeaders = map[string]bool{
+	"connection":        true,
+	"keep-alive":        true,
+	"proxy-connection":  true,
+	"transfer-encoding": true,
+	"te":                true,
+	"trailer":           true,
+	"upgrade":           true,
+	"content-length":    true,
+}
+
+// Differ sends request of every captured transaction to candidate backend,
+// compares its response with production one, and prints differences of
+// status code, selected headers and json body fields. It wraps the printer
+// used by pairing, requests are sent by background workers so capture is
+// never blocked.
+type Differ struct {
+	printer decoder.Printer
+	target  string
+	headers []string
+	fields  []string
+	client  *http.Client
+	queue   chan *decoder.Transaction
+	wg      sync.WaitGroup
+}
+
+// Print drops single msg, only transactions can be compared.
+func (d *Differ) Print(msg *decoder.Message) error {
+	return nil
+}
+
+func (d *Differ) PrintTransaction(t *decoder.Transaction) error {
+	if t.Request == nil || t.Response == nil {
+		return nil
+	}
+	select {
+	case d.queue <- t:
+	default:
+		log.Println("diff queue is full, skip:", t.Request.Fields["url"])
+	}
+	return nil
+}
+
+// Close waits queued transactions to be compared.
+func (d *Differ) Close() {
+	close(d.queue)
+	d.wg.Wait()
+}
+
+func (d *Differ) run() {
+	defer d.wg.Done()
+	for t := range d.queue {
+		req, ok1 := t.Request.Decoded.(*HttpReq)
+		resp, ok2 := t.Response.Decoded.(*HttpResp)
+		if !ok1 || !ok2 {
+			continue
+		}
+		diffs, err := d.diff(req, resp)
+		if err != nil {
+			diffs = map[string][2]interface{}{"error": {nil, err.Error()}}
+		}
+		if len(diffs) == 0 {
+			continue
+		}
+		d.printer.Print(d.message(t, req, diffs))
+	}
+}
+
+func (d *Differ) diff(req *HttpReq, resp *HttpResp) (map[string][2]interface{}, error) {
+	candidate, body, err := d.send(req)
+	if err != nil {
+		return nil, err
+	}
+	diffs := make(map[string][2]interface{})
+	if resp.statusCode != candidate.StatusCode {
+		diffs["status"] = [2]interface{}{resp.statusCode, candidate.StatusCode}
+	}
+	for _, h := range d.headers {
+		if v1, v2 := resp.headers[h], candidate.Header.Get(h); v1 != v2 {
+			diffs["header "+h] = [2]interface{}{v1, v2}
+		}
+	}
+	body1, err := decompress(resp.headers["content-encoding"], resp.body)
+	if err != nil {
+		return nil, err
+	}
+	body2, err := decompress(candidate.Header.Get("content-encoding"), body)
+	if err != nil {
+		return nil, err
+	}
+	var v1, v2 interface{}
+	if json.Unmarshal(body1, &v1) == nil && json.Unmarshal(body2, &v2) == nil {
+		diffJSON("$", v1, v2, diffs)
+	} else if !bytes.Equal(body1, body2) {
+		diffs["body"] = [2]interface{}{fmt.Sprintf("%d bytes", len(body1)), fmt.Sprintf("%d bytes", len(body2))}
+	}
+	for path := range diffs {
+		if strings.HasPrefix(path, "$") && !d.selected(path) {
+			delete(diffs, path)
+		}
+	}
+	return diffs, nil
+}
+
+// send replays captured request to candidate
+func (d *Differ) send(req *HttpReq) (*http.Response, []byte, error) {
+	r, err := http.NewRequest(req.method, "http://"+d.target+req.url, bytes.NewReader(req.body))
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range req.headers {
+		if !hopHeaders[k] {
+			r.Header.Set(k, v)
+		}
+	}
+	if host, ok := req.headers["host"]; ok {
+		r.Host = host
+	}
+	resp, err := d.client.Do(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, body, nil
+}
+
+// selected checks json path against fields to compare, all fields are
+// compared if not specified.
+func (d *Differ) selected(path string) bool {
+	if len(d.fields) == 0 {
+		return true
+	}
+	for _, f := range d.fields {
+		f = "$." + f
+		if path == f || strings.HasPrefix(path, f+".") || strings.HasPrefix(path, f+"[") {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Differ) message(t *decoder.Transaction, req *HttpReq, diffs map[string][2]interface{}) *decoder.Message {
+	keys := make([]string, 0, len(diffs))
+	for k := range diffs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	text := fmt.Sprintf("DIFF %s %s\n", req.method, req.url)
+	result := make(map[string]interface{})
+	for _, k := range keys {
+		text += fmt.Sprintf("  %s: %v != %v\n", k, jsonString(diffs[k][0]), jsonString(diffs[k][1]))
+		result[k] = map[string]interface{}{"production": diffs[k][0], "candidate": diffs[k][1]}
+	}
+	return &decoder.Message{
+		Protocol:  "http-diff",
+		Direction: decoder.Response,
+		Src:       t.Request.Src,
+		Dst:       t.Request.Dst,
+		Timestamp: t.Response.Timestamp,
+		Fields: map[string]interface{}{
+			"method": req.method,
+			"url":    req.url,
+			"diff":   result,
+		},
+		Text: text,
+	}
+}
+
+func jsonString(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+// diffJSON records different values between a and b under json path.
+func diffJSON(path string, a, b interface{}, diffs map[string][2]interface{}) {
+	switch va := a.(type) {
+	case map[string]interface{}:
+		vb, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make(map[string]bool)
+		for k := range va {
+			keys[k] = true
+		}
+		for k := range vb {
+			keys[k] = true
+		}
+		for k := range keys {
+			diffJSON(path+"."+k, va[k], vb[k], diffs)
+		}
+		return
+	case []interface{}:
+		vb, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(va) || i < len(vb); i++ {
+			var ea, eb interface{}
+			if i < len(va) {
+				ea = va[i]
+			}
+			if i < len(vb) {
+				eb = vb[i]
+			}
+			diffJSON(path+"["+strconv.Itoa(i)+"]", ea, eb, diffs)
+		}
+		return
+	}
+	if !reflect.DeepEqual(a, b) {
+		diffs[path] = [2]interface{}{a, b}
+	}
+}
+
+// NewDiffer creates differ sending requests to target host:port, headers are
+// names to compare, fields are json body fields to compare, eg: data.id,
+// all fields are compared if it's empty.
+func NewDiffer(printer decoder.Printer, target string, headers, fields []string) *Differ {
+	d := &Differ{
+		printer: printer,
+		target:  target,
+		headers: headers,
+		fields:  fields,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		queue: make(chan *decoder.Transaction, 1024),
+	}
+	for i := range d.headers {
+		d.headers[i] = strings.ToLower(strings.TrimSpace(d.headers[i]))
+	}
+	workers := 4
+	d.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go d.run()
+	}
+	return d
+}
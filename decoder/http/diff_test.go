@@ -0,0 +1,49 @@
+package http
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/monsterxx03/pipe/decoder"
+)
+
+func TestDiffJSON(t *testing.T) {
+	diffs := make(map[string][2]interface{})
+	diffJSON("$", map[string]interface{}{"a": 1.0, "b": []interface{}{"x"}, "c": "same"},
+		map[string]interface{}{"a": 2.0, "b": []interface{}{"x", "y"}, "c": "same"}, diffs)
+	assertEqual(t, len(diffs), 2)
+	assertEqual(t, diffs["$.a"], [2]interface{}{1.0, 2.0})
+	assertEqual(t, diffs["$.b[1]"], [2]interface{}{nil, "y"})
+}
+
+func TestDiffer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Host != "example.com" || string(body) != "hi" {
+			w.WriteHeader(400)
+			return
+		}
+		w.Header().Set("Etag", "2")
+		w.Write([]byte(`{"data":{"id":1,"name":"b"},"ts":2}`))
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	printer, _ := decoder.NewPrinter("text", &buf)
+	differ := NewDiffer(printer, strings.TrimPrefix(server.URL, "http://"), []string{"ETag"}, []string{"data"})
+	req := &HttpReq{method: "POST", url: "/x", version: "HTTP/1.1",
+		headers: map[string]string{"host": "example.com", "content-length": "2"}, body: []byte("hi")}
+	resp := &HttpResp{version: "HTTP/1.1", statusCode: 200, statusMsg: "OK",
+		headers: map[string]string{"etag": "1"}, body: []byte(`{"data":{"id":1,"name":"a"},"ts":1}`)}
+	differ.PrintTransaction(&decoder.Transaction{Request: req.Message(), Response: resp.Message()})
+	// same response, nothing printed
+	same := &HttpResp{version: "HTTP/1.1", statusCode: 200, headers: map[string]string{"etag": "2"},
+		body: []byte(`{"data":{"name":"b","id":1},"ts":3}`)}
+	differ.PrintTransaction(&decoder.Transaction{Request: req.Message(), Response: same.Message()})
+	differ.Close()
+	assertEqual(t, buf.String(), "DIFF POST /x\n  $.data.name: \"a\" != \"b\"\n  header etag: \"1\" != \"2\"\n")
+}
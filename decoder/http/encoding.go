@@ -0,0 +1,55 @@
+package http
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/juju/errors"
+)
+
+// max size of decompressed body, protects from compression bombs
+const maxDecompressed = 16 << 20
+
+// decompress decodes data by content-encoding, codings are listed in the
+// order they were applied, eg: "gzip, br" is decoded by br first.
+func decompress(encoding string, data []byte) ([]byte, error) {
+	if encoding == "" || len(data) == 0 {
+		return data, nil
+	}
+	codings := strings.Split(encoding, ",")
+	for i := len(codings) - 1; i >= 0; i-- {
+		var r io.Reader
+		var err error
+		switch coding := strings.ToLower(strings.TrimSpace(codings[i])); coding {
+		case "gzip", "x-gzip":
+			r, err = gzip.NewReader(bytes.NewReader(data))
+		case "deflate":
+			// should be zlib format, but some servers send raw deflate
+			if r, err = zlib.NewReader(bytes.NewReader(data)); err != nil {
+				r, err = flate.NewReader(bytes.NewReader(data)), nil
+			}
+		case "br":
+			r = brotli.NewReader(bytes.NewReader(data))
+		case "identity", "":
+			continue
+		default:
+			return nil, errors.New("Unsupported content-encoding: " + coding)
+		}
+		if err != nil {
+			return nil, errors.Annotate(err, "content-encoding "+codings[i])
+		}
+		if data, err = ioutil.ReadAll(io.LimitReader(r, maxDecompressed+1)); err != nil {
+			return nil, errors.Annotate(err, "content-encoding "+codings[i])
+		}
+		if len(data) > maxDecompressed {
+			return nil, errors.Errorf("content-encoding %s: decompressed body exceeds %d bytes", codings[i], maxDecompressed)
+		}
+	}
+	return data, nil
+}
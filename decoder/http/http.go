@@ -2,7 +2,7 @@ package http
 
 import (
 	"bufio"
-	"github.com/juju/errors"
+	"bytes"
 	"github.com/monsterxx03/pipe/decoder"
 	"io"
 	"log"
@@ -10,59 +10,110 @@ import (
 	"strings"
 )
 
-var SKIP = errors.New("Skip msg")
-
 type Decoder struct {
 	buf    *bufio.Reader
 	filter *Filter
+	// start line found by resync
+	pending string
 }
 
-func (d *Decoder) Decode(reader io.Reader, writer io.Writer, opts *decoder.Options) error {
+func (d *Decoder) Decode(reader io.Reader, writer decoder.MessageWriter, opts *decoder.Options) error {
 	d.buf = bufio.NewReader(reader)
 	for {
+		if d.pending == "" {
+			// EOF between msgs is normal end of stream
+			if _, err := d.buf.Peek(1); err != nil {
+				return err
+			}
+		}
 		msg, err := d.decodeHttp()
 		if err != nil {
-			if err == SKIP {
-				continue
+			if !decoder.IsMalformed(err) {
+				return decoder.Truncated(err)
+			}
+			decoder.ReportError(writer, err)
+			if err := d.resync(); err != nil {
+				return err
 			}
-			log.Println(err)
 			continue
 		}
-		writer.Write([]byte(msg.StringHeader()))
+		body := string(msg.RawBody())
 		if opts.DeepDecode {
-			_msg, err := msg.DecodeBody()
-			if err != nil {
+			// show raw body if it can't be decoded
+			if decoded, err := msg.DecodeBody(); err != nil {
 				log.Println(err)
-				continue
+			} else {
+				body = decoded
 			}
-			writer.Write([]byte(_msg))
-		} else {
-			writer.Write([]byte(msg.RawBody()))
 		}
-		writer.Write([]byte("\n"))
+		m := msg.Message()
+		m.Body = body
+		m.Text = msg.StringHeader() + body + "\n"
+		// unmatched msg is still written, so the paired response/request
+		// can be skipped too
+		m.Skip = !d.filter.IsEmpty() && !msg.Match(d.filter)
+		// interim response, eg: 100 Continue, is followed by the final one
+		// of the same request, so it's printed alone
+		if resp, ok := msg.(*HttpResp); ok && resp.statusCode/100 == 1 && resp.statusCode != 101 {
+			m.NoReply = true
+		}
+		if err := writer.WriteMessage(m); err != nil {
+			return err
+		}
 	}
-	return nil
 }
 
-func (d *Decoder) decodeHttp() (Http, error) {
-	isReq := true
-	firstLine, err := d.buf.ReadString('\n')
-	if err != nil {
-		return nil, err
+// resync skips lines until a request or status line.
+func (d *Decoder) resync() error {
+	for {
+		line, err := d.buf.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if isStartLine(strings.TrimRight(line, "\r\n")) {
+			d.pending = line
+			return nil
+		}
 	}
-	firstLine = firstLine[:len(firstLine)-2]
-	f := strings.SplitN(firstLine, " ", 3)
-	fLen := len(f)
-	if fLen < 3 {
-		return nil, errors.New("bad http msg: " + firstLine)
-	} else if fLen == 3 {
-		if f[0][:2] == "HT" { // eg: HTTP/1.1 200 OK
-			isReq = false
+}
+
+// isStartLine checks request line, eg: GET / HTTP/1.1, or status line,
+// eg: HTTP/1.1 200 OK
+func isStartLine(line string) bool {
+	f := strings.SplitN(line, " ", 3)
+	if len(f) < 2 {
+		return false
+	}
+	if strings.HasPrefix(f[0], "HTTP/") {
+		code, err := strconv.Atoi(f[1])
+		return err == nil && code >= 100 && code < 600
+	}
+	if len(f) != 3 || !strings.HasPrefix(f[2], "HTTP/") {
+		return false
+	}
+	for _, c := range f[0] {
+		if c < 'A' || c > 'Z' {
+			return false
 		}
-	} else {
-		isReq = false
 	}
-	if isReq {
+	return true
+}
+
+func (d *Decoder) decodeHttp() (Http, error) {
+	var err error
+	firstLine := d.pending
+	d.pending = ""
+	if firstLine == "" {
+		if firstLine, err = d.buf.ReadString('\n'); err != nil {
+			return nil, err
+		}
+	}
+	firstLine = strings.TrimRight(firstLine, "\r\n")
+	if !isStartLine(firstLine) {
+		return nil, decoder.Malformed("bad http start line: " + strconv.Quote(firstLine))
+	}
+	f := strings.SplitN(firstLine, " ", 3)
+	if !strings.HasPrefix(f[0], "HTTP/") {
 		req := new(HttpReq)
 		req.method = f[0]
 		req.url = f[1]
@@ -71,27 +122,24 @@ func (d *Decoder) decodeHttp() (Http, error) {
 		if err != nil {
 			return nil, err
 		}
-		req.body = parseBody(req.headers, d.buf)
-		if !d.filter.IsEmpty() && !req.Match(d.filter) {
-			return nil, SKIP
+		if req.body, err = parseBody(req.headers, d.buf); err != nil {
+			return nil, err
 		}
 		return req, nil
 	} else {
 		// it's http response
 		resp := new(HttpResp)
 		resp.version = f[0]
-		resp.statusCode, err = strconv.Atoi(f[1])
-		if err != nil {
-			return nil, errors.New("Invalid http resp: " + firstLine)
+		resp.statusCode, _ = strconv.Atoi(f[1])
+		if len(f) == 3 {
+			resp.statusMsg = f[2]
 		}
-		resp.statusMsg = f[2]
 		resp.headers, err = parseHeaders(d.buf)
 		if err != nil {
 			return nil, err
 		}
-		resp.body = parseBody(resp.headers, d.buf)
-		if !d.filter.IsEmpty() && !resp.Match(d.filter) {
-			return nil, SKIP
+		if resp.body, err = parseBody(resp.headers, d.buf); err != nil {
+			return nil, err
 		}
 		return resp, nil
 	}
@@ -106,26 +154,91 @@ func parseHeaders(buf *bufio.Reader) (map[string]string, error) {
 		if line, err = buf.ReadString('\n'); err != nil {
 			return nil, err
 		}
-		if line == "\r\n" {
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
 			// end of header
 			break
 		}
-		line = line[:len(line)-2] // remove \r\n
 		result := strings.SplitN(line, ":", 2)
+		if len(result) != 2 {
+			return nil, decoder.Malformed("bad http header: " + strconv.Quote(line))
+		}
 		headers[strings.ToLower(strings.TrimSpace(result[0]))] = strings.TrimSpace(result[1])
 	}
 	return headers, nil
 }
 
-func parseBody(headers map[string]string, reader *bufio.Reader) []byte {
+// max size of http body
+const maxBodyLen = 64 << 20
+
+// readN reads n bytes, buffer grows as data arrives, so a garbage length
+// doesn't allocate it at once.
+func readN(reader io.Reader, n int64) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, reader, n); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func parseBody(headers map[string]string, reader *bufio.Reader) ([]byte, error) {
+	if isChunked(headers) {
+		return parseChunkedBody(headers, reader)
+	}
 	length, ok := headers["content-length"]
 	if ok {
-		bodyLen, _ := strconv.Atoi(length)
-		body := make([]byte, bodyLen)
-		reader.Read(body)
-		return body
+		bodyLen, err := strconv.ParseInt(length, 10, 64)
+		if err != nil || bodyLen < 0 || bodyLen > maxBodyLen {
+			return nil, decoder.Malformed("Invalid content-length: " + length)
+		}
+		return readN(reader, bodyLen)
+	}
+	return nil, nil
+}
+
+// transfer-encoding overrides content-length, chunked must be the last one,
+// eg: Transfer-Encoding: gzip, chunked
+func isChunked(headers map[string]string) bool {
+	te, ok := headers["transfer-encoding"]
+	if !ok {
+		return false
+	}
+	codings := strings.Split(te, ",")
+	return strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked")
+}
+
+// parseChunkedBody reads chunks until the last zero size chunk, trailers
+// after it are merged into headers.
+func parseChunkedBody(headers map[string]string, reader *bufio.Reader) ([]byte, error) {
+	var body []byte
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		// chunk size may be followed by extensions, eg: 1a;name=value
+		sizeStr := strings.TrimSpace(strings.SplitN(line, ";", 2)[0])
+		size, err := strconv.ParseInt(sizeStr, 16, 64)
+		if err != nil || size < 0 || int64(len(body))+size > maxBodyLen {
+			return nil, decoder.Malformed("Invalid chunk size: " + sizeStr)
+		}
+		if size == 0 {
+			break
+		}
+		chunk, err := readN(reader, size+2) // with ending \r\n
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, chunk[:size]...)
+	}
+	trailers, err := parseHeaders(reader)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range trailers {
+		headers[k] = v
 	}
-	return nil
+	return body, nil
 }
 
 func (d *Decoder) SetFilter(filter string) {
@@ -133,5 +246,11 @@ func (d *Decoder) SetFilter(filter string) {
 }
 
 func init() {
-	decoder.Register("http", new(Decoder))
+	decoder.Register(&decoder.Info{
+		Name:        "http",
+		Description: "http/1.x, body is decoded by content type with -dd",
+		New: func(args map[string]string) (decoder.Decoder, error) {
+			return new(Decoder), nil
+		},
+	})
 }
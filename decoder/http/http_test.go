@@ -3,7 +3,14 @@ package http
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
 	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/monsterxx03/pipe/decoder"
 )
 
 func assertEqual(t *testing.T, result interface{}, expected interface{}) {
@@ -45,24 +52,42 @@ func TestDecodeHttpResp(t *testing.T) {
 	assertEqual(t, string(resp.body), "Hello World")
 }
 
+func decodeWithFilter(t *testing.T, filter, data string) []*decoder.Message {
+	d := Decoder{}
+	d.SetFilter(filter)
+	var c errCollector
+	if err := d.Decode(bytes.NewReader([]byte(data)), &c, new(decoder.Options)); err != io.EOF {
+		t.Error("expect EOF, got:", err)
+	}
+	return c.msgs
+}
+
 func TestHttpReqFilter(t *testing.T) {
-	decoder := Decoder{}
-	decoder.SetFilter("url: /test & method: POST")
-	data := []byte("POST /tes/haha HTTP/1.1\r\nHost: google.com\r\nUser-Agent:curl\r\n\r\nHello\r\n")
-	decoder.buf = bufio.NewReader(bytes.NewReader(data))
-	_data, err := decoder.decodeHttp()
-	assertEqual(t, _data, nil)
-	assertEqual(t, err, SKIP)
+	// unmatched msgs are written with Skip, so pairing stays in order
+	msgs := decodeWithFilter(t, "url: /test & method: POST",
+		"POST /tes/haha HTTP/1.1\r\nHost: google.com\r\nUser-Agent:curl\r\n\r\n"+
+			"POST /test/hahax HTTP/1.1\r\nHost: google.com\r\nUser-Agent:curl\r\n\r\n")
+	assertEqual(t, len(msgs), 2)
+	assertEqual(t, msgs[0].Skip, true)
+	assertEqual(t, msgs[1].Skip, false)
+	assertEqual(t, msgs[1].Fields["url"], "/test/hahax")
 
-	// match
-	data = []byte("POST /test/hahax HTTP/1.1\r\nHost: google.com\r\nUser-Agent:curl\r\n\r\nHello\r\n")
-	decoder.buf = bufio.NewReader(bytes.NewReader(data))
-	_data, err = decoder.decodeHttp()
-	if err != nil {
-		t.Fatal(err)
-	}
-	req := _data.(*HttpReq)
-	assertEqual(t, req.url, "/test/hahax")
+	// request rules don't apply to responses
+	msgs = decodeWithFilter(t, "url: /test", "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	assertEqual(t, len(msgs), 1)
+	assertEqual(t, msgs[0].Skip, false)
+}
+
+func TestHttpRespFilter(t *testing.T) {
+	msgs := decodeWithFilter(t, "statusCode: 500",
+		"HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\nHTTP/1.1 500 Error\r\nContent-Length: 0\r\n\r\n")
+	assertEqual(t, len(msgs), 2)
+	assertEqual(t, msgs[0].Skip, true)
+	assertEqual(t, msgs[1].Skip, false)
+
+	msgs = decodeWithFilter(t, "statusCode: 500", "GET / HTTP/1.1\r\n\r\n")
+	assertEqual(t, len(msgs), 1)
+	assertEqual(t, msgs[0].Skip, false)
 }
 
 func TestHttpFilterPlainString(t *testing.T) {
@@ -87,3 +112,174 @@ func TestHttpFilterRegexp(t *testing.T) {
 	assertEqual(t, ok, true)
 	assertEqual(t, pattern.MatchString("put"), true)
 }
+
+func TestDecodeHttpChunked(t *testing.T) {
+	decoder := Decoder{}
+	decoder.SetFilter("")
+	data := []byte("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: Expires\r\n\r\n" +
+		"5\r\nHello\r\n6;ext=1\r\n World\r\n0\r\nExpires: never\r\n\r\n" +
+		"HTTP/1.1 204 No Content\r\n\r\n")
+	decoder.buf = bufio.NewReader(bytes.NewReader(data))
+	_data, err := decoder.decodeHttp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := _data.(*HttpResp)
+	assertEqual(t, string(resp.body), "Hello World")
+	assertEqual(t, resp.headers["expires"], "never")
+
+	// next msg is not broken by chunked body
+	_data, err = decoder.decodeHttp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, _data.(*HttpResp).statusCode, 204)
+}
+
+func TestDecodeHttpChunkedNoTrailer(t *testing.T) {
+	decoder := Decoder{}
+	decoder.SetFilter("")
+	data := []byte("POST /upload HTTP/1.1\r\nTransfer-Encoding: gzip, chunked\r\n\r\n" +
+		"3\r\nabc\r\n0\r\n\r\n")
+	decoder.buf = bufio.NewReader(bytes.NewReader(data))
+	_data, err := decoder.decodeHttp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, string(_data.(*HttpReq).body), "abc")
+}
+
+func TestDecodeHttpChunkTooLarge(t *testing.T) {
+	d := Decoder{}
+	d.SetFilter("")
+	data := []byte("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"7fffffffffffffff\r\nabc\r\n0\r\n\r\n")
+	d.buf = bufio.NewReader(bytes.NewReader(data))
+	_, err := d.decodeHttp()
+	assertEqual(t, decoder.IsMalformed(err), true)
+}
+
+func TestDecompressBody(t *testing.T) {
+	data := []byte(`{"hello": "world"}`)
+	var gz, zl, br bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(data)
+	gw.Close()
+	zw := zlib.NewWriter(&zl)
+	zw.Write(data)
+	zw.Close()
+	bw := brotli.NewWriter(&br)
+	bw.Write(gz.Bytes()) // gzip then br
+	bw.Close()
+
+	for _, c := range []struct {
+		encoding string
+		body     []byte
+	}{
+		{"", data},
+		{"gzip", gz.Bytes()},
+		{"deflate", zl.Bytes()},
+		{"gzip, br", br.Bytes()},
+	} {
+		result, err := decompress(c.encoding, c.body)
+		if err != nil {
+			t.Fatal(c.encoding, err)
+		}
+		assertEqual(t, string(result), string(data))
+	}
+
+	resp := &HttpResp{headers: map[string]string{"content-encoding": "gzip"}, body: gz.Bytes()}
+	body, err := resp.DecodeBody()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, body, string(data))
+
+	if _, err := decompress("compress", data); err == nil {
+		t.Error("expect unsupported encoding error")
+	}
+}
+
+func TestDecompressBomb(t *testing.T) {
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(make([]byte, maxDecompressed+1))
+	gw.Close()
+	if _, err := decompress("gzip", gz.Bytes()); err == nil {
+		t.Error("expect error for body exceeds limit")
+	}
+}
+
+type errCollector struct {
+	msgs   []*decoder.Message
+	errors int
+}
+
+func (c *errCollector) WriteMessage(msg *decoder.Message) error {
+	c.msgs = append(c.msgs, msg)
+	return nil
+}
+
+func (c *errCollector) ReportError(err error) {
+	c.errors++
+}
+
+func TestDecodeHttpResync(t *testing.T) {
+	// capture starts in the middle of a body
+	data := "lo world\r\nmore body\r\nGET /a HTTP/1.1\r\nHost: b\r\n\r\n" +
+		"GET /b HTTP/1.1\r\nbad header\r\n\r\nGET /c HTTP/1.1\r\n\r\n"
+	d := Decoder{}
+	d.SetFilter("")
+	var c errCollector
+	err := d.Decode(bytes.NewReader([]byte(data)), &c, new(decoder.Options))
+	assertEqual(t, err, io.EOF)
+	assertEqual(t, len(c.msgs), 2)
+	assertEqual(t, c.msgs[0].Fields["url"], "/a")
+	assertEqual(t, c.msgs[1].Fields["url"], "/c")
+	assertEqual(t, c.errors, 2)
+}
+
+func TestDecodeHttpTruncated(t *testing.T) {
+	d := Decoder{}
+	d.SetFilter("")
+	var c errCollector
+	data := "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nabc"
+	err := d.Decode(bytes.NewReader([]byte(data)), &c, new(decoder.Options))
+	assertEqual(t, err, decoder.ErrTruncated)
+	assertEqual(t, len(c.msgs), 0)
+}
+
+func TestDecodeHttpBodyTooLarge(t *testing.T) {
+	d := Decoder{}
+	d.SetFilter("")
+	data := []byte("HTTP/1.1 200 OK\r\nContent-Length: 9223372036854775807\r\n\r\nabc")
+	d.buf = bufio.NewReader(bytes.NewReader(data))
+	_, err := d.decodeHttp()
+	assertEqual(t, decoder.IsMalformed(err), true)
+}
+
+func TestDecodeHttpContinue(t *testing.T) {
+	d := Decoder{}
+	d.SetFilter("")
+	var c errCollector
+	data := "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok" +
+		"HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\n\r\n"
+	err := d.Decode(bytes.NewReader([]byte(data)), &c, new(decoder.Options))
+	assertEqual(t, err, io.EOF)
+	assertEqual(t, len(c.msgs), 3)
+	// only the final response is paired with request
+	assertEqual(t, c.msgs[0].NoReply, true)
+	assertEqual(t, c.msgs[1].NoReply, false)
+	assertEqual(t, c.msgs[2].NoReply, false)
+
+	var out bytes.Buffer
+	printer, _ := decoder.NewPrinter("text", &out)
+	p := decoder.NewPairing(printer)
+	p.Add(&decoder.Message{Direction: decoder.Request, Text: "POST /upload\n"})
+	for _, m := range c.msgs[:2] {
+		m.Text = fmt.Sprintf("%d\n", m.Fields["statusCode"])
+		p.Add(m)
+	}
+	p.Close()
+	assertEqual(t, out.String(), "100\nPOST /upload\n200\n(0s)\n")
+}
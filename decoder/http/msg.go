@@ -1,9 +1,9 @@
 package http
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
+	"github.com/juju/errors"
+	"github.com/monsterxx03/pipe/decoder"
 	"github.com/ugorji/go/codec"
 	"reflect"
 	"regexp"
@@ -17,92 +17,42 @@ type Http interface {
 	StringHeader() string
 	DecodeBody() (string, error)
 	RawBody() []byte
-}
-
-func prettyPrint(v map[string]interface{}) map[string]interface{} {
-	result := make(map[string]interface{})
-	for key, value := range v {
-		switch reflect.ValueOf(value).Kind() {
-		case reflect.Map:
-			result[key] = _prettyMap(value.(map[interface{}]interface{}))
-		case reflect.Slice:
-			result[key] = _prettySlice(value)
-		default:
-			result[key] = value
-		}
-	}
-	return result
-}
-
-func _prettyMap(m map[interface{}]interface{}) map[string]interface{} {
-	result := make(map[string]interface{})
-	for k, v := range m {
-		switch reflect.ValueOf(v).Kind() {
-		case reflect.Map:
-			result[k.(string)] = _prettyMap(v.(map[interface{}]interface{}))
-		case reflect.Slice:
-			if data, ok := v.([]byte); ok {
-				result[k.(string)] = string(data)
-			} else {
-				result[k.(string)] = _prettySlice(v)
-			}
-		default:
-			switch k.(type) {
-			case int:
-				result[strconv.Itoa(k.(int))] = v
-			case int8:
-				result[strconv.Itoa(int(k.(int8)))] = v
-			case int16:
-				result[strconv.Itoa(int(k.(int16)))] = v
-			case int32:
-				result[strconv.Itoa(int(k.(int32)))] = v
-			case int64:
-				result[strconv.Itoa(int(k.(int64)))] = v
-			default:
-				result[k.(string)] = v
+	Message() *decoder.Message
+}
+
+// prettyValue converts decoded msgpack/cbor value to be marshaled as json,
+// map keys of any type are converted to string, []byte to string.
+func prettyValue(v interface{}) (interface{}, error) {
+	if data, ok := v.([]byte); ok {
+		return string(data), nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Map:
+		result := make(map[string]interface{}, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			value, err := prettyValue(iter.Value().Interface())
+			if err != nil {
+				return nil, err
 			}
+			result[fmt.Sprint(iter.Key().Interface())] = value
 		}
-	}
-	return result
-}
-
-func _prettySlice(s interface{}) []interface{} {
-	result := make([]interface{}, 0)
-	rs := reflect.ValueOf(s)
-	for i := 0; i < rs.Len(); i++ {
-		v := rs.Index(i).Interface()
-		switch reflect.ValueOf(v).Kind() {
-		case reflect.Map:
-			result = append(result, _prettyMap(v.(map[interface{}]interface{})))
-		case reflect.Slice:
-			if data, ok := v.([]byte); ok {
-				result = append(result, string(data))
-			} else {
-				result = append(result, _prettySlice(v))
+		return result, nil
+	case reflect.Slice, reflect.Array:
+		result := make([]interface{}, rv.Len())
+		for i := range result {
+			value, err := prettyValue(rv.Index(i).Interface())
+			if err != nil {
+				return nil, err
 			}
-		default:
-			result = append(result, v)
-		}
-	}
-	return result
-}
-
-func decodeToString(contentType string, data []byte) (string, error) {
-	var v map[string]interface{}
-	switch contentType {
-	case "application/msgpack":
-		dec := codec.NewDecoder(bytes.NewReader(data), &mh)
-		err := dec.Decode(&v)
-		if err != nil {
-			return "", err
-		}
-		pv, err := json.MarshalIndent(prettyPrint(v), "", " ")
-		if err != nil {
-			return "", err
+			result[i] = value
 		}
-		return fmt.Sprint(string(pv)), nil
+		return result, nil
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return nil, errors.Errorf("unsupported value type: %T", v)
 	}
-	return string(data), nil
+	return v, nil
 }
 
 type HttpReq struct {
@@ -118,7 +68,25 @@ func (m *HttpReq) RawBody() []byte {
 }
 
 func (m *HttpReq) DecodeBody() (string, error) {
-	return decodeToString(m.headers["content-type"], m.body)
+	body, err := decompress(m.headers["content-encoding"], m.body)
+	if err != nil {
+		return "", err
+	}
+	return decodeToString(m.headers["content-type"], body)
+}
+
+func (m *HttpReq) Message() *decoder.Message {
+	return &decoder.Message{
+		Protocol:  "http",
+		Direction: decoder.Request,
+		Decoded:   m,
+		Fields: map[string]interface{}{
+			"method":  m.method,
+			"url":     m.url,
+			"version": m.version,
+			"headers": m.headers,
+		},
+	}
 }
 
 func (m *HttpReq) StringHeader() string {
@@ -148,6 +116,9 @@ func (m *HttpReq) Match(filter *Filter) bool {
 		return false
 	}
 	delete(filters, "body")
+	// rules of response
+	delete(filters, "statusCode")
+	delete(filters, "statusMsg")
 	if len(filters) > 0 && !matchHeaders(filters, m.headers) {
 		return false
 	}
@@ -167,7 +138,25 @@ func (m *HttpResp) RawBody() []byte {
 }
 
 func (m *HttpResp) DecodeBody() (string, error) {
-	return decodeToString(m.headers["content-type"], m.body)
+	body, err := decompress(m.headers["content-encoding"], m.body)
+	if err != nil {
+		return "", err
+	}
+	return decodeToString(m.headers["content-type"], body)
+}
+
+func (m *HttpResp) Message() *decoder.Message {
+	return &decoder.Message{
+		Protocol:  "http",
+		Direction: decoder.Response,
+		Decoded:   m,
+		Fields: map[string]interface{}{
+			"version":    m.version,
+			"statusCode": m.statusCode,
+			"statusMsg":  m.statusMsg,
+			"headers":    m.headers,
+		},
+	}
 }
 
 func (m *HttpResp) StringHeader() string {
@@ -175,7 +164,7 @@ func (m *HttpResp) StringHeader() string {
 	for k, v := range m.headers {
 		headStr += k + ": " + v + "\r\n"
 	}
-	return fmt.Sprintf("%s %s %s\r\n%s\r\n", m.version, m.statusCode, m.statusMsg, headStr)
+	return fmt.Sprintf("%s %d %s\r\n%s\r\n", m.version, m.statusCode, m.statusMsg, headStr)
 }
 
 func (m *HttpResp) Match(filter *Filter) bool {
@@ -197,6 +186,9 @@ func (m *HttpResp) Match(filter *Filter) bool {
 		return false
 	}
 	delete(filters, "body")
+	// rules of request
+	delete(filters, "method")
+	delete(filters, "url")
 	if len(filters) > 0 && !matchHeaders(filters, m.headers) {
 		return false
 	}
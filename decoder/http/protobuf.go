@@ -0,0 +1,159 @@
+package http
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/juju/errors"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var (
+	protoFiles *protoregistry.Files
+	protoType  string
+)
+
+// LoadProtoDescriptor loads descriptor set file generated by
+// `protoc --include_imports --descriptor_set_out`. msgType is full name of
+// message used if content type doesn't tell it,
+// eg: application/x-protobuf; messageType=foo.Bar
+func LoadProtoDescriptor(path, msgType string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		return errors.Annotate(err, "bad descriptor set "+path)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return errors.Annotate(err, "bad descriptor set "+path)
+	}
+	if msgType != "" {
+		if _, err := findProtoMessage(files, msgType); err != nil {
+			return err
+		}
+	}
+	protoFiles, protoType = files, msgType
+	return nil
+}
+
+func findProtoMessage(files *protoregistry.Files, name string) (protoreflect.MessageDescriptor, error) {
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, errors.Annotate(err, "protobuf message "+name)
+	}
+	md, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, errors.New("not a protobuf message: " + name)
+	}
+	return md, nil
+}
+
+// decodeProtobuf decodes body by loaded descriptor, or decodes raw like
+// `protoc --decode_raw` if message type is unknown.
+func decodeProtobuf(data []byte, params map[string]string) (string, error) {
+	name := protoType
+	for _, k := range []string{"messagetype", "proto", "type"} {
+		if v, ok := params[k]; ok {
+			name = v
+			break
+		}
+	}
+	if protoFiles == nil || name == "" {
+		return decodeProtoRaw(data, "")
+	}
+	md, err := findProtoMessage(protoFiles, name)
+	if err != nil {
+		return "", err
+	}
+	msg := dynamicpb.NewMessage(md)
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return "", err
+	}
+	result, err := protojson.MarshalOptions{Multiline: true, Indent: " "}.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+	return string(result), nil
+}
+
+func decodeProtoRaw(data []byte, indent string) (string, error) {
+	result := ""
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return "", protowire.ParseError(n)
+		}
+		data = data[n:]
+		var value string
+		switch typ {
+		case protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(data)
+			value = strconv.FormatUint(v, 10)
+		case protowire.Fixed32Type:
+			var v uint32
+			v, n = protowire.ConsumeFixed32(data)
+			value = fmt.Sprintf("0x%08x", v)
+		case protowire.Fixed64Type:
+			var v uint64
+			v, n = protowire.ConsumeFixed64(data)
+			value = fmt.Sprintf("0x%016x", v)
+		case protowire.BytesType:
+			var v []byte
+			v, n = protowire.ConsumeBytes(data)
+			value = protoBytesValue(v, indent)
+		case protowire.StartGroupType:
+			var v []byte
+			v, n = protowire.ConsumeGroup(num, data)
+			if n >= 0 {
+				nested, err := decodeProtoRaw(v, indent+" ")
+				if err != nil {
+					return "", err
+				}
+				value = "{\n" + nested + indent + "}"
+			}
+		default:
+			return "", errors.Errorf("unexpected protobuf wire type %d", typ)
+		}
+		if n < 0 {
+			return "", protowire.ParseError(n)
+		}
+		data = data[n:]
+		result += fmt.Sprintf("%s%d: %s\n", indent, num, value)
+	}
+	return result, nil
+}
+
+// length delimited field may be string, bytes or nested message
+func protoBytesValue(v []byte, indent string) string {
+	if isPrintable(v) {
+		return strconv.Quote(string(v))
+	}
+	if nested, err := decodeProtoRaw(v, indent+" "); err == nil {
+		return "{\n" + nested + indent + "}"
+	}
+	return strconv.Quote(string(v))
+}
+
+func isPrintable(v []byte) bool {
+	if !utf8.Valid(v) {
+		return false
+	}
+	return strings.IndexFunc(string(v), func(r rune) bool {
+		return !unicode.IsPrint(r) && !unicode.IsSpace(r)
+	}) == -1
+}
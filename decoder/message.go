@@ -0,0 +1,67 @@
+package decoder
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type Direction int
+
+const (
+	UnknownDirection Direction = iota
+	Request
+	Response
+	// Push is sent by server without request, eg: redis pub/sub msg
+	Push
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Request:
+		return "request"
+	case Response:
+		return "response"
+	case Push:
+		return "push"
+	}
+	return "unknown"
+}
+
+func (d Direction) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// Message is a decoded protocol message.
+type Message struct {
+	Protocol  string                 `json:"protocol"`
+	Direction Direction              `json:"direction"`
+	Src       string                 `json:"src"`
+	Dst       string                 `json:"dst"`
+	Timestamp time.Time              `json:"timestamp"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+	Body      string                 `json:"body,omitempty"`
+	// Text is human readable form of the message, used by text output
+	Text string `json:"-"`
+	// Decoded is the decoder specific msg, eg: *http.HttpReq
+	Decoded interface{} `json:"-"`
+	// Skip is set if msg doesn't match filter, it's not printed, neither is
+	// the transaction it belongs to
+	Skip bool `json:"-"`
+	// NoReply is set on request never replied by server, eg: redis
+	// SUBSCRIBE, or response not ending a request, eg: http 100 Continue,
+	// it's printed at once instead of being paired
+	NoReply bool `json:"-"`
+}
+
+// Transaction is a request paired with its response, one of them is nil if
+// it's never paired.
+type Transaction struct {
+	Request  *Message      `json:"request,omitempty"`
+	Response *Message      `json:"response,omitempty"`
+	Elapsed  time.Duration `json:"elapsed_ns,omitempty"`
+}
+
+// MessageWriter receives messages from decoder.
+type MessageWriter interface {
+	WriteMessage(*Message) error
+}
@@ -0,0 +1,73 @@
+package decoder
+
+import (
+	"sync"
+)
+
+// Merger is implemented by decoded requests shown together with their
+// responses, eg: redis EXEC shows queued commands with their replies.
+type Merger interface {
+	Merge(req, resp *Message)
+}
+
+// Pairing is shared by both directions of a connection, it matches requests
+// with responses in FIFO order (keep-alive and pipelining), and prints them
+// as one transaction with elapsed time.
+type Pairing struct {
+	mu      sync.Mutex
+	printer Printer
+	reqs    []*Message
+	resps   []*Message
+}
+
+// Add adds a decoded message, request and response are decoded concurrently,
+// so response may come first, it waits for its request. Push and request
+// without reply are printed alone.
+func (p *Pairing) Add(msg *Message) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if msg.Direction == Push || msg.NoReply {
+		if !msg.Skip {
+			p.printer.Print(msg)
+		}
+		return
+	}
+	if msg.Direction == Response {
+		p.resps = append(p.resps, msg)
+	} else {
+		p.reqs = append(p.reqs, msg)
+	}
+	for len(p.reqs) > 0 && len(p.resps) > 0 {
+		req, resp := p.reqs[0], p.resps[0]
+		p.reqs, p.resps = p.reqs[1:], p.resps[1:]
+		if req.Skip || resp.Skip {
+			continue
+		}
+		if m, ok := req.Decoded.(Merger); ok {
+			m.Merge(req, resp)
+		}
+		p.printer.PrintTransaction(&Transaction{req, resp, resp.Timestamp.Sub(req.Timestamp)})
+	}
+}
+
+// Close prints messages never paired, eg: connection is closed before
+// server replies, or capture started in the middle of a request.
+func (p *Pairing) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, req := range p.reqs {
+		if !req.Skip {
+			p.printer.PrintTransaction(&Transaction{Request: req})
+		}
+	}
+	for _, resp := range p.resps {
+		if !resp.Skip {
+			p.printer.PrintTransaction(&Transaction{Response: resp})
+		}
+	}
+	p.reqs, p.resps = nil, nil
+}
+
+func NewPairing(printer Printer) *Pairing {
+	return &Pairing{printer: printer}
+}
@@ -0,0 +1,58 @@
+package decoder
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestPairingResponseFirst(t *testing.T) {
+	var out bytes.Buffer
+	printer, _ := NewPrinter("text", &out)
+	p := NewPairing(printer)
+	now := time.Now()
+	// response stream may be decoded before request stream
+	p.Add(&Message{Direction: Response, Timestamp: now.Add(3 * time.Millisecond), Text: "OK\n"})
+	if out.Len() != 0 {
+		t.Fatal("response written before request")
+	}
+	p.Add(&Message{Direction: Request, Timestamp: now, Text: "set a 1\n"})
+	p.Add(&Message{Direction: Response, Timestamp: now.Add(time.Second), Text: "nil\n"})
+	p.Close()
+	expected := "set a 1\nOK\n(3ms)\nnil\n(no request)\n"
+	if out.String() != expected {
+		t.Errorf("result: %q \n no match expected: %q", out.String(), expected)
+	}
+}
+
+func TestPairingSkip(t *testing.T) {
+	var out bytes.Buffer
+	printer, _ := NewPrinter("text", &out)
+	p := NewPairing(printer)
+	now := time.Now()
+	p.Add(&Message{Direction: Request, Timestamp: now, Text: "get a\n", Skip: true})
+	p.Add(&Message{Direction: Request, Timestamp: now, Text: "set a 1\n"})
+	p.Add(&Message{Direction: Response, Timestamp: now, Text: "1\n"})
+	p.Add(&Message{Direction: Response, Timestamp: now, Text: "-ERR\n", Skip: true})
+	p.Add(&Message{Direction: Request, Timestamp: now, Text: "del a\n", Skip: true})
+	p.Close()
+	if out.Len() != 0 {
+		t.Errorf("skipped transaction printed: %q", out.String())
+	}
+}
+
+func TestPairingPush(t *testing.T) {
+	var out bytes.Buffer
+	printer, _ := NewPrinter("text", &out)
+	p := NewPairing(printer)
+	now := time.Now()
+	p.Add(&Message{Direction: Request, Timestamp: now, Text: "subscribe a\n", NoReply: true})
+	p.Add(&Message{Direction: Push, Timestamp: now, Text: "subscribe a 1\n"})
+	p.Add(&Message{Direction: Request, Timestamp: now, Text: "ping\n"})
+	p.Add(&Message{Direction: Push, Timestamp: now, Text: "message a hi\n"})
+	p.Add(&Message{Direction: Response, Timestamp: now.Add(time.Millisecond), Text: "pong\n"})
+	p.Close()
+	if out.String() != "subscribe a\nsubscribe a 1\nmessage a hi\nping\npong\n(1ms)\n" {
+		t.Errorf("bad output: %q", out.String())
+	}
+}
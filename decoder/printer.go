@@ -0,0 +1,88 @@
+package decoder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/juju/errors"
+)
+
+// Printer renders messages and transactions to output, it's safe to be used
+// by multiple streams.
+type Printer interface {
+	Print(*Message) error
+	PrintTransaction(*Transaction) error
+}
+
+// NewPrinter returns printer for format: text, json or ndjson.
+func NewPrinter(format string, w io.Writer) (Printer, error) {
+	switch format {
+	case "text":
+		return &textPrinter{w: w}, nil
+	case "json":
+		return &jsonPrinter{w: w, indent: true}, nil
+	case "ndjson":
+		return &jsonPrinter{w: w}, nil
+	}
+	return nil, errors.New("Unknown output format: " + format)
+}
+
+type textPrinter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (p *textPrinter) Print(msg *Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := io.WriteString(p.w, msg.Text)
+	return err
+}
+
+func (p *textPrinter) PrintTransaction(t *Transaction) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var err error
+	switch {
+	case t.Response == nil:
+		_, err = fmt.Fprintf(p.w, "%s(no response)\n", t.Request.Text)
+	case t.Request == nil:
+		_, err = fmt.Fprintf(p.w, "%s(no request)\n", t.Response.Text)
+	default:
+		_, err = fmt.Fprintf(p.w, "%s%s(%s)\n", t.Request.Text, t.Response.Text, t.Elapsed)
+	}
+	return err
+}
+
+type jsonPrinter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	indent bool
+}
+
+func (p *jsonPrinter) print(v interface{}) error {
+	var data []byte
+	var err error
+	if p.indent {
+		data, err = json.MarshalIndent(v, "", " ")
+	} else {
+		data, err = json.Marshal(v)
+	}
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err = p.w.Write(append(data, '\n'))
+	return err
+}
+
+func (p *jsonPrinter) Print(msg *Message) error {
+	return p.print(msg)
+}
+
+func (p *jsonPrinter) PrintTransaction(t *Transaction) error {
+	return p.print(t)
+}
@@ -0,0 +1,45 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestNDJSONPrinter(t *testing.T) {
+	var out bytes.Buffer
+	p, err := NewPrinter("ndjson", &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2017, 11, 2, 8, 30, 0, 0, time.UTC)
+	req := &Message{Protocol: "redis", Direction: Request, Src: "10.0.0.1:5000", Dst: "10.0.0.2:6379",
+		Timestamp: ts, Body: "get a", Text: "get a\n"}
+	resp := &Message{Protocol: "redis", Direction: Response, Src: "10.0.0.2:6379", Dst: "10.0.0.1:5000",
+		Timestamp: ts.Add(time.Millisecond), Body: "1", Text: "1\n"}
+	p.Print(req)
+	p.PrintTransaction(&Transaction{req, resp, time.Millisecond})
+
+	lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("expect 2 lines, got: %q", out.String())
+	}
+	expected := `{"protocol":"redis","direction":"request","src":"10.0.0.1:5000","dst":"10.0.0.2:6379","timestamp":"2017-11-02T08:30:00Z","body":"get a"}`
+	if string(lines[0]) != expected {
+		t.Errorf("result: %s \n no match expected: %s", lines[0], expected)
+	}
+	var tx map[string]interface{}
+	if err := json.Unmarshal(lines[1], &tx); err != nil {
+		t.Fatal(err)
+	}
+	if tx["elapsed_ns"].(float64) != 1e6 || tx["response"].(map[string]interface{})["direction"] != "response" {
+		t.Errorf("bad transaction: %s", lines[1])
+	}
+}
+
+func TestUnknownPrinter(t *testing.T) {
+	if _, err := NewPrinter("xml", nil); err == nil {
+		t.Error("expect error for unknown format")
+	}
+}
@@ -0,0 +1,158 @@
+package redis
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// number of hash slots in redis cluster
+const clusterSlots = 16384
+
+// crc16 is CRC16-CCITT (XMODEM) used by redis cluster
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// keySlot returns hash slot of key, only the hash tag is hashed if key has
+// one, eg: {user1000}.following
+func keySlot(key []byte) int {
+	if start := bytes.IndexByte(key, '{'); start >= 0 {
+		if end := bytes.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key)) % clusterSlots
+}
+
+// keySlots returns slots of keys in a command without duplication, nil if
+// the command has no key or its keys are unknown.
+func (m *redisMsg) keySlots() []int {
+	var slots []int
+	seen := make(map[int]bool)
+	for _, key := range m.keys() {
+		slot := keySlot(key)
+		if !seen[slot] {
+			seen[slot] = true
+			slots = append(slots, slot)
+		}
+	}
+	return slots
+}
+
+// redirect parses cluster redirection, eg: -MOVED 3999 127.0.0.1:6381,
+// kind is empty if msg isn't one.
+func (m *redisMsg) redirect() (kind string, slot int, node string) {
+	if m.typ != respERROR {
+		return
+	}
+	f := strings.Fields(string(m.value))
+	if len(f) != 3 || (f[0] != "MOVED" && f[0] != "ASK") {
+		return
+	}
+	slot, err := strconv.Atoi(f[1])
+	if err != nil {
+		return
+	}
+	return strings.ToLower(f[0]), slot, f[2]
+}
+
+// clusterNode formats node of CLUSTER SLOTS, eg: 127.0.0.1:7000
+func clusterNode(m *redisMsg) (string, bool) {
+	if m.typ != respArray || len(m.elems) < 2 || m.elems[1].typ != respInt {
+		return "", false
+	}
+	return string(m.elems[0].value) + ":" + string(m.elems[1].value), true
+}
+
+// formatClusterSlots formats reply of CLUSTER SLOTS as one line per range,
+// master comes first, eg: 0-5460 127.0.0.1:7000 127.0.0.1:7003, ok is false
+// if reply isn't in that shape.
+func (m *redisMsg) formatClusterSlots() (string, bool) {
+	if m.typ != respArray || len(m.elems) == 0 {
+		return "", false
+	}
+	lines := make([]string, 0, len(m.elems))
+	for _, e := range m.elems {
+		if e.typ != respArray || len(e.elems) < 3 || e.elems[0].typ != respInt || e.elems[1].typ != respInt {
+			return "", false
+		}
+		line := string(e.elems[0].value) + "-" + string(e.elems[1].value)
+		for _, n := range e.elems[2:] {
+			node, ok := clusterNode(n)
+			if !ok {
+				return "", false
+			}
+			line += " " + node
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n"), true
+}
+
+// mapValue looks up key in map reply, which is an array of keys and values
+// in resp2.
+func (m *redisMsg) mapValue(key string) *redisMsg {
+	if m.typ != respArray && m.typ != respMap {
+		return nil
+	}
+	for i := 0; i+1 < len(m.elems); i += 2 {
+		if string(m.elems[i].value) == key {
+			return m.elems[i+1]
+		}
+	}
+	return nil
+}
+
+// formatClusterShards formats reply of CLUSTER SHARDS as one line per shard,
+// eg: 0-5460 127.0.0.1:7000 (master, online) 127.0.0.1:7003 (replica, online)
+func (m *redisMsg) formatClusterShards() (string, bool) {
+	if m.typ != respArray || len(m.elems) == 0 {
+		return "", false
+	}
+	lines := make([]string, 0, len(m.elems))
+	for _, shard := range m.elems {
+		slots, nodes := shard.mapValue("slots"), shard.mapValue("nodes")
+		if slots == nil || nodes == nil || slots.typ != respArray || nodes.typ != respArray {
+			return "", false
+		}
+		var ranges []string
+		for i := 0; i+1 < len(slots.elems); i += 2 {
+			ranges = append(ranges, string(slots.elems[i].value)+"-"+string(slots.elems[i+1].value))
+		}
+		line := strings.Join(ranges, ",")
+		if line == "" {
+			line = "(no slots)"
+		}
+		for _, n := range nodes.elems {
+			ip, port := n.mapValue("ip"), n.mapValue("port")
+			if ip == nil || port == nil {
+				return "", false
+			}
+			line += fmt.Sprintf(" %s:%s", ip.value, port.value)
+			var state []string
+			for _, k := range []string{"role", "health"} {
+				if v := n.mapValue(k); v != nil {
+					state = append(state, string(v.value))
+				}
+			}
+			if len(state) > 0 {
+				line += " (" + strings.Join(state, ", ") + ")"
+			}
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n"), true
+}
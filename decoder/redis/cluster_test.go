@@ -0,0 +1,114 @@
+package redis
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	dp "github.com/monsterxx03/pipe/decoder"
+)
+
+func TestKeySlot(t *testing.T) {
+	// values from CLUSTER KEYSLOT
+	for key, slot := range map[string]int{"123456789": 12739, "foo": 12182, "{foo}bar": 12182} {
+		if s := keySlot([]byte(key)); s != slot {
+			t.Errorf("slot of %s: %d, expected %d", key, s, slot)
+		}
+	}
+	// whole key is hashed if hash tag is empty or not closed
+	for key, hashed := range map[string]string{"{user1000}.following": "user1000", "{}foo": "{}foo",
+		"foo{}{bar}": "foo{}{bar}", "foo{{bar}}": "{bar", "foo{bar": "foo{bar"} {
+		if keySlot([]byte(key)) != int(crc16([]byte(hashed)))%clusterSlots {
+			t.Errorf("bad hash tag of %s", key)
+		}
+	}
+}
+
+func TestRedisSlots(t *testing.T) {
+	data := "*2\r\n$3\r\nget\r\n$3\r\nfoo\r\n" +
+		"*3\r\n$4\r\nMGET\r\n$3\r\nfoo\r\n$9\r\n123456789\r\n" +
+		"*5\r\n$4\r\nmset\r\n$3\r\nfoo\r\n$1\r\n1\r\n$8\r\n{foo}bar\r\n$1\r\n2\r\n" +
+		"*1\r\n$4\r\nping\r\n"
+	d := Decoder{showSlots: true}
+	d.SetFilter("slot: ^12182$")
+	var msgs msgCollector
+	d.Decode(bytes.NewReader([]byte(data)), &msgs, &dp.Options{IsRequest: true})
+	if len(msgs) != 4 {
+		t.Fatalf("expect 4 msgs, got %d", len(msgs))
+	}
+	for i, expected := range []string{"get foo (slot 12182)\n", "MGET foo 123456789 (slots 12182,12739)\n",
+		"mset foo 1 {foo}bar 2 (slot 12182)\n", "ping\n"} {
+		if msgs[i].Text != expected {
+			t.Errorf("result: %q\n no match expected: %q", msgs[i].Text, expected)
+		}
+	}
+	if msgs[1].Fields["slot"] != 12182 || fmt.Sprint(msgs[1].Fields["slots"]) != "[12182 12739]" {
+		t.Error("bad fields:", msgs[1].Fields)
+	}
+	for i, skip := range []bool{false, false, false, true} {
+		if msgs[i].Skip != skip {
+			t.Errorf("msg %q skip: %v", msgs[i].Body, msgs[i].Skip)
+		}
+	}
+}
+
+func TestRedisKeySlots(t *testing.T) {
+	for _, c := range []struct {
+		args  []string
+		slots string
+	}{
+		{[]string{"eval", "return 1", "1", "foo"}, "[12182]"},
+		{[]string{"xread", "block", "0", "streams", "foo", "0"}, "[12182]"},
+		{[]string{"object", "encoding", "foo"}, "[12182]"},
+		{[]string{"brpop", "foo", "123456789", "0"}, "[12182 12739]"},
+		{[]string{"rename", "foo", "123456789"}, "[12182 12739]"},
+		{[]string{"sunionstore", "{foo}d", "foo", "123456789"}, "[12182 12739]"},
+		{[]string{"ping", "foo"}, "[]"},
+	} {
+		if slots := fmt.Sprint(command(c.args...).keySlots()); slots != c.slots {
+			t.Errorf("slots of %v: %s, expected %s", c.args, slots, c.slots)
+		}
+	}
+}
+
+func TestRedisRedirect(t *testing.T) {
+	msgs := decodeWithFilter(t, "slot: ^3999$", false, "-MOVED 3999 127.0.0.1:6381\r\n-ASK 100 10.0.0.1:7000\r\n+OK\r\n")
+	if len(msgs) != 3 {
+		t.Fatalf("expect 3 msgs, got %d", len(msgs))
+	}
+	f := msgs[0].Fields
+	if f["redirect"] != "moved" || f["slot"] != 3999 || f["node"] != "127.0.0.1:6381" || msgs[0].Skip {
+		t.Error("bad moved:", f, msgs[0].Skip)
+	}
+	if msgs[1].Fields["redirect"] != "ask" || !msgs[1].Skip {
+		t.Error("bad ask:", msgs[1].Fields, msgs[1].Skip)
+	}
+	if msgs[2].Skip {
+		t.Error("reply without slot skipped")
+	}
+}
+
+func TestClusterSlotsReply(t *testing.T) {
+	data := "*2\r\n" +
+		"*4\r\n:0\r\n:5460\r\n*3\r\n$9\r\n127.0.0.1\r\n:7000\r\n$2\r\nid\r\n*3\r\n$9\r\n127.0.0.1\r\n:7003\r\n$2\r\nid\r\n" +
+		"*3\r\n:5461\r\n:16383\r\n*2\r\n$9\r\n127.0.0.1\r\n:7001\r\n"
+	msgs := decodeWithFilter(t, "", false, data)
+	if msgs[0].Body != "0-5460 127.0.0.1:7000 127.0.0.1:7003\n5461-16383 127.0.0.1:7001" ||
+		msgs[0].Fields["kind"] != "cluster slots" {
+		t.Errorf("bad cluster slots: %q %v", msgs[0].Body, msgs[0].Fields)
+	}
+}
+
+func TestClusterShardsReply(t *testing.T) {
+	node := func(port, role string) string {
+		return "*8\r\n$2\r\nip\r\n$9\r\n127.0.0.1\r\n$4\r\nport\r\n:" + port + "\r\n" +
+			"$4\r\nrole\r\n$" + fmt.Sprint(len(role)) + "\r\n" + role + "\r\n$6\r\nhealth\r\n$6\r\nonline\r\n"
+	}
+	data := "*1\r\n*4\r\n$5\r\nslots\r\n*4\r\n:0\r\n:100\r\n:200\r\n:300\r\n$5\r\nnodes\r\n*2\r\n" +
+		node("7000", "master") + node("7003", "replica")
+	msgs := decodeWithFilter(t, "", false, data)
+	expected := "0-100,200-300 127.0.0.1:7000 (master, online) 127.0.0.1:7003 (replica, online)"
+	if msgs[0].Body != expected || msgs[0].Fields["kind"] != "cluster shards" {
+		t.Errorf("bad cluster shards: %q %v", msgs[0].Body, msgs[0].Fields)
+	}
+}
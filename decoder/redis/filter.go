@@ -0,0 +1,64 @@
+package redis
+
+import (
+	"github.com/monsterxx03/pipe/decoder"
+	"regexp"
+	"strconv"
+)
+
+// cmd: ^(SET|DEL)$ & key: ^session: & reply: ^-ERR & slot: ^3999$
+// cmd and key are matched against requests, reply against responses, slot
+// against hash slots of keys and slot of -MOVED/-ASK replies
+type Filter struct {
+	filterStr string
+	filters   map[string]*regexp.Regexp
+}
+
+func (f Filter) String() string {
+	return f.filterStr
+}
+
+func (f *Filter) IsEmpty() bool {
+	return len(f.filters) == 0
+}
+
+// Match checks msg with rules of its direction, msg always matches if there's
+// no rule for its direction.
+func (f *Filter) Match(direction decoder.Direction, msg *redisMsg) bool {
+	if f == nil || f.IsEmpty() {
+		return true
+	}
+	if direction == decoder.Response {
+		if pattern, ok := f.filters["reply"]; ok && !pattern.MatchString(msg.reply()) {
+			return false
+		}
+		// replies other than redirections match
+		if pattern, ok := f.filters["slot"]; ok {
+			if kind, slot, _ := msg.redirect(); kind != "" && !pattern.MatchString(strconv.Itoa(slot)) {
+				return false
+			}
+		}
+		return true
+	}
+	cmd, key := msg.cmd()
+	if pattern, ok := f.filters["cmd"]; ok && !pattern.MatchString(cmd) {
+		return false
+	}
+	if pattern, ok := f.filters["key"]; ok && !pattern.MatchString(key) {
+		return false
+	}
+	if pattern, ok := f.filters["slot"]; ok {
+		// any key in the slot
+		for _, slot := range msg.keySlots() {
+			if pattern.MatchString(strconv.Itoa(slot)) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func NewFilter(filterStr string) *Filter {
+	return &Filter{filterStr, decoder.ParseFilter(filterStr)}
+}
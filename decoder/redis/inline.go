@@ -0,0 +1,142 @@
+package redis
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/monsterxx03/pipe/decoder"
+)
+
+// isInlineStart checks whether a request line may be an inline command,
+// eg: PING, command names are letters.
+func isInlineStart(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+// decodeInline decodes an inline command sent by telnet or health checkers,
+// eg: SET a "hello world". It's returned as an array of bulk strings, nil
+// is returned for empty line, which is ignored by redis too.
+func (d *Decoder) decodeInline() (*redisMsg, error) {
+	line, err := d.buf.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = bytes.TrimRight(line, "\r\n")
+	if len(bytes.TrimSpace(line)) == 0 {
+		return nil, nil
+	}
+	if !isInlineStart(line[0]) {
+		return nil, decoder.Malformed("request is not an array or inline command")
+	}
+	args, err := splitArgs(line)
+	if err != nil {
+		return nil, err
+	}
+	msg := &redisMsg{typ: respArray}
+	for _, arg := range args {
+		msg.elems = append(msg.elems, &redisMsg{typ: respString, value: arg})
+	}
+	return msg, nil
+}
+
+// splitArgs splits line like redis-server does for inline commands, args
+// are separated by spaces, and can be quoted. Double quoted arg supports
+// escapes, eg: "a\r\n\x00", single quoted arg only supports \'.
+func splitArgs(line []byte) ([][]byte, error) {
+	var args [][]byte
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+		var arg []byte
+		inDouble, inSingle, done := false, false, false
+		for !done {
+			if inDouble {
+				if i == len(line) {
+					return nil, decoder.Malformed("unbalanced quotes in inline command")
+				}
+				c := line[i]
+				switch {
+				case c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]):
+					v, _ := strconv.ParseUint(string(line[i+2:i+4]), 16, 8)
+					arg = append(arg, byte(v))
+					i += 3
+				case c == '\\' && i+1 < len(line):
+					i++
+					switch line[i] {
+					case 'n':
+						arg = append(arg, '\n')
+					case 'r':
+						arg = append(arg, '\r')
+					case 't':
+						arg = append(arg, '\t')
+					case 'b':
+						arg = append(arg, '\b')
+					case 'a':
+						arg = append(arg, '\a')
+					default:
+						arg = append(arg, line[i])
+					}
+				case c == '"':
+					// closing quote must be followed by a space or nothing
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, decoder.Malformed("unbalanced quotes in inline command")
+					}
+					done = true
+				default:
+					arg = append(arg, c)
+				}
+			} else if inSingle {
+				if i == len(line) {
+					return nil, decoder.Malformed("unbalanced quotes in inline command")
+				}
+				c := line[i]
+				switch {
+				case c == '\\' && i+1 < len(line) && line[i+1] == '\'':
+					arg = append(arg, '\'')
+					i++
+				case c == '\'':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, decoder.Malformed("unbalanced quotes in inline command")
+					}
+					done = true
+				default:
+					arg = append(arg, c)
+				}
+			} else {
+				if i == len(line) {
+					break
+				}
+				switch c := line[i]; {
+				case isSpace(c):
+					done = true
+				case c == '"':
+					inDouble = true
+				case c == '\'':
+					inSingle = true
+				default:
+					arg = append(arg, c)
+				}
+			}
+			if i < len(line) {
+				i++
+			}
+		}
+		if arg == nil {
+			arg = []byte{}
+		}
+		args = append(args, arg)
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func isHex(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
@@ -0,0 +1,146 @@
+package redis
+
+import (
+	"strconv"
+	"strings"
+)
+
+// keySpec tells positions of keys in a command, like key specs of redis
+// COMMAND, positions count from the command name.
+type keySpec struct {
+	first, last int // last < 0 counts from the end, 0 means no fixed key
+	step        int
+	numkeys     int  // position of numkeys arg, keys follow it
+	streams     bool // keys are the first half of args after STREAMS
+}
+
+var (
+	oneKey   = keySpec{first: 1, last: 1, step: 1}
+	twoKeys  = keySpec{first: 1, last: 2, step: 1}
+	allKeys  = keySpec{first: 1, last: -1, step: 1}
+	subKey   = keySpec{first: 2, last: 2, step: 1} // after subcommand
+	pairKeys = keySpec{first: 1, last: -1, step: 2}
+	// last arg is timeout
+	timeoutKeys = keySpec{first: 1, last: -2, step: 1}
+)
+
+// keySpecs of commands with keys, commands not here have no key or unknown
+// keys, their args are never taken as keys.
+var keySpecs = map[string]keySpec{
+	"del": allKeys, "unlink": allKeys, "exists": allKeys, "touch": allKeys, "watch": allKeys,
+	"mget": allKeys, "sinter": allKeys, "sunion": allKeys, "sdiff": allKeys,
+	"sinterstore": allKeys, "sunionstore": allKeys, "sdiffstore": allKeys,
+	"pfcount": allKeys, "pfmerge": allKeys,
+	"mset": pairKeys, "msetnx": pairKeys,
+	"blpop": timeoutKeys, "brpop": timeoutKeys, "bzpopmin": timeoutKeys, "bzpopmax": timeoutKeys,
+	"rename": twoKeys, "renamenx": twoKeys, "copy": twoKeys, "smove": twoKeys,
+	"lmove": twoKeys, "blmove": twoKeys, "rpoplpush": twoKeys, "brpoplpush": twoKeys,
+	"zrangestore": twoKeys, "geosearchstore": twoKeys,
+	"object": subKey, "xinfo": subKey, "xgroup": subKey, "bitop": {first: 2, last: -1, step: 1},
+	"eval": {numkeys: 2}, "evalsha": {numkeys: 2}, "eval_ro": {numkeys: 2}, "evalsha_ro": {numkeys: 2},
+	"fcall": {numkeys: 2}, "fcall_ro": {numkeys: 2},
+	"zunion": {numkeys: 1}, "zinter": {numkeys: 1}, "zdiff": {numkeys: 1},
+	"zintercard": {numkeys: 1}, "sintercard": {numkeys: 1}, "lmpop": {numkeys: 1}, "zmpop": {numkeys: 1},
+	"blmpop": {numkeys: 2}, "bzmpop": {numkeys: 2},
+	"xread": {streams: true}, "xreadgroup": {streams: true},
+	// destination and keys after numkeys
+	"zunionstore": {first: 1, last: 1, step: 1, numkeys: 2},
+	"zinterstore": {first: 1, last: 1, step: 1, numkeys: 2},
+	"zdiffstore":  {first: 1, last: 1, step: 1, numkeys: 2},
+}
+
+func init() {
+	for _, cmd := range strings.Fields(`get set setnx setex psetex getset getdel getex append strlen
+		incr decr incrby decrby incrbyfloat getrange setrange substr getbit setbit bitcount bitpos
+		bitfield bitfield_ro hget hset hsetnx hmset hmget hdel hlen hstrlen hkeys hvals hgetall hexists
+		hincrby hincrbyfloat hscan hrandfield lpush rpush lpushx rpushx lpop rpop llen lrange lindex
+		lset linsert lrem ltrim lpos sadd srem smembers sismember smismember scard spop srandmember
+		sscan zadd zrem zcard zcount zincrby zrange zrangebyscore zrevrange zrevrangebyscore
+		zrangebylex zrevrangebylex zlexcount zrank zrevrank zscore zmscore zremrangebyrank
+		zremrangebyscore zremrangebylex zpopmin zpopmax zscan zrandmember xadd xlen xrange xrevrange
+		xdel xtrim xack xclaim xautoclaim xpending xsetid expire pexpire expireat pexpireat
+		expiretime pexpiretime ttl pttl persist type dump restore move sort sort_ro pfadd geoadd
+		geodist geohash geopos georadius georadiusbymember georadius_ro georadiusbymember_ro geosearch`) {
+		keySpecs[cmd] = oneKey
+	}
+}
+
+// keys returns keys of a command by its key spec, nil if it has no key or
+// its keys are unknown.
+func (m *redisMsg) keys() [][]byte {
+	cmd, _ := m.cmd()
+	spec, ok := keySpecs[strings.ToLower(cmd)]
+	if !ok {
+		return nil
+	}
+	args := make([][]byte, len(m.elems))
+	for i, e := range m.elems {
+		args[i] = e.value
+	}
+	var keys [][]byte
+	if spec.first > 0 {
+		last := spec.last
+		if last < 0 {
+			last += len(args)
+		}
+		for i := spec.first; i <= last && i < len(args); i += spec.step {
+			keys = append(keys, args[i])
+		}
+	}
+	if spec.numkeys > 0 && spec.numkeys < len(args) {
+		n, err := strconv.Atoi(string(args[spec.numkeys]))
+		if err != nil || n < 0 || spec.numkeys+n >= len(args) {
+			return nil
+		}
+		keys = append(keys, args[spec.numkeys+1:spec.numkeys+1+n]...)
+	}
+	if spec.streams {
+		for i, arg := range args {
+			if strings.EqualFold(string(arg), "streams") {
+				rest := args[i+1:]
+				keys = append(keys, rest[:len(rest)/2]...)
+				break
+			}
+		}
+	}
+	return keys
+}
+
+// valueSpec tells positions of values written by a command, step 0 means
+// only one value.
+type valueSpec struct {
+	first, step int
+}
+
+var valueSpecs = map[string]valueSpec{
+	"set": {2, 0}, "setnx": {2, 0}, "getset": {2, 0}, "append": {2, 0},
+	"setex": {3, 0}, "psetex": {3, 0}, "setrange": {3, 0}, "lset": {3, 0}, "hsetnx": {3, 0}, "linsert": {4, 0},
+	"mset": {2, 2}, "msetnx": {2, 2}, "hset": {3, 2}, "hmset": {3, 2},
+	"lpush": {2, 1}, "rpush": {2, 1}, "lpushx": {2, 1}, "rpushx": {2, 1}, "sadd": {2, 1},
+}
+
+// values returns size of the largest value written to each key by a write
+// command, values of mset belong to the keys before them.
+func (m *redisMsg) values() map[string]int {
+	cmd, _ := m.cmd()
+	spec, ok := valueSpecs[strings.ToLower(cmd)]
+	if !ok || len(m.elems) <= spec.first {
+		return nil
+	}
+	last := len(m.elems) - 1
+	step := spec.step
+	if step == 0 {
+		last, step = spec.first, 1
+	}
+	result := make(map[string]int)
+	for i := spec.first; i <= last; i += step {
+		key := string(m.elems[1].value)
+		if cmd := strings.ToLower(cmd); cmd == "mset" || cmd == "msetnx" {
+			key = string(m.elems[i-1].value)
+		}
+		if n := len(m.elems[i].value); n > result[key] {
+			result[key] = n
+		}
+	}
+	return result
+}
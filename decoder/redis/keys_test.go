@@ -0,0 +1,59 @@
+package redis
+
+import (
+	"fmt"
+	"testing"
+)
+
+// command builds a request from args
+func command(args ...string) *redisMsg {
+	m := &redisMsg{typ: respArray}
+	for _, arg := range args {
+		m.elems = append(m.elems, &redisMsg{typ: respString, value: []byte(arg)})
+	}
+	return m
+}
+
+func TestRedisKeys(t *testing.T) {
+	for _, c := range []struct {
+		args []string
+		keys string
+	}{
+		{[]string{"GET", "a"}, "[a]"},
+		{[]string{"auth", "s3cr3t"}, "[]"},
+		{[]string{"select", "0"}, "[]"},
+		{[]string{"config", "get", "x"}, "[]"},
+		{[]string{"unknowncmd", "a"}, "[]"},
+		{[]string{"mget", "k1", "k2"}, "[k1 k2]"},
+		{[]string{"mset", "k1", "v1", "k2", "v2"}, "[k1 k2]"},
+		{[]string{"blpop", "l1", "l2", "0"}, "[l1 l2]"},
+		{[]string{"rename", "a", "b"}, "[a b]"},
+		{[]string{"object", "encoding", "a"}, "[a]"},
+		{[]string{"bitop", "and", "d", "a", "b"}, "[d a b]"},
+		{[]string{"eval", "return 1", "1", "foo", "arg"}, "[foo]"},
+		{[]string{"eval", "return 1", "3", "foo"}, "[]"},
+		{[]string{"zunionstore", "d", "2", "a", "b", "weights", "1", "2"}, "[d a b]"},
+		{[]string{"blmpop", "0", "2", "a", "b", "left"}, "[a b]"},
+		{[]string{"xread", "count", "2", "block", "0", "streams", "s1", "s2", "0", "0"}, "[s1 s2]"},
+	} {
+		if keys := fmt.Sprintf("%s", command(c.args...).keys()); keys != c.keys {
+			t.Errorf("keys of %v: %s, expected %s", c.args, keys, c.keys)
+		}
+	}
+}
+
+func TestRedisValues(t *testing.T) {
+	for _, c := range []struct {
+		args   []string
+		values string
+	}{
+		{[]string{"set", "a", "hello", "ex", "100"}, "map[a:5]"},
+		{[]string{"mset", "k1", "v", "k2", "value"}, "map[k1:1 k2:5]"},
+		{[]string{"hset", "h", "field", "v", "f", "val"}, "map[h:3]"},
+		{[]string{"mget", "k1", "k2"}, "map[]"},
+	} {
+		if values := fmt.Sprint(command(c.args...).values()); values != c.values {
+			t.Errorf("values of %v: %s, expected %s", c.args, values, c.values)
+		}
+	}
+}
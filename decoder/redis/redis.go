@@ -2,9 +2,13 @@ package redis
 
 import (
 	"bufio"
+	"bytes"
+	"fmt"
 	"github.com/juju/errors"
 	"github.com/monsterxx03/pipe/decoder"
 	"io"
+	"strconv"
+	"strings"
 )
 
 const (
@@ -13,82 +17,512 @@ const (
 	respInt    = ':'
 	respString = '$'
 	respArray  = '*'
+
+	// resp3, negotiated by HELLO 3
+	respNull      = '_'
+	respDouble    = ','
+	respBool      = '#'
+	respBigNumber = '('
+	respBlobError = '!'
+	respVerbatim  = '='
+	respMap       = '%'
+	respSet       = '~'
+	respPush      = '>'
+	respAttribute = '|'
 )
 
-var NIL = []byte("nil")
+// max size of redis string
+const maxBulkLen = 512 * 1024 * 1024
+
+var respTypes = []byte{respOK, respERROR, respInt, respString, respArray,
+	respNull, respDouble, respBool, respBigNumber, respBlobError, respVerbatim,
+	respMap, respSet, respPush, respAttribute}
+
+// IsRespType tells whether b is type byte of a resp msg, eg: * of array
+func IsRespType(b byte) bool {
+	return bytes.IndexByte(respTypes, b) != -1
+}
+
+// redisMsg is a decoded resp msg, a tree for nested aggregate types.
+type redisMsg struct {
+	typ   byte
+	value []byte      // simple types and bulk string
+	elems []*redisMsg // aggregate elements, keys and values in turn for map
+	isNil bool        // $-1, *-1 or resp3 null
+	attrs *redisMsg   // resp3 attribute sent before the reply
+	// commands queued by MULTI if msg is EXEC
+	queued []*redisMsg
+}
+
+func (m *redisMsg) isAggregate() bool {
+	switch m.typ {
+	case respArray, respMap, respSet, respPush, respAttribute:
+		return true
+	}
+	return false
+}
+
+// args returns values of all leaves, nested arrays are flattened.
+func (m *redisMsg) args() [][]byte {
+	if !m.isAggregate() {
+		return [][]byte{m.value}
+	}
+	var result [][]byte
+	for _, e := range m.elems {
+		result = append(result, e.args()...)
+	}
+	return result
+}
+
+func (m *redisMsg) Bytes() []byte {
+	return bytes.Join(m.args(), []byte(" "))
+}
+
+// size returns bytes of msg on wire, inline command is counted as array.
+func (m *redisMsg) size() int {
+	n := 0
+	if m.attrs != nil {
+		n += m.attrs.size()
+	}
+	switch {
+	case m.isNil:
+		return n + 5 // $-1\r\n
+	case m.isAggregate():
+		length := len(m.elems)
+		if m.typ == respMap || m.typ == respAttribute {
+			length /= 2
+		}
+		n += 3 + len(strconv.Itoa(length))
+		for _, e := range m.elems {
+			n += e.size()
+		}
+		return n
+	case m.typ == respString || m.typ == respBlobError || m.typ == respVerbatim:
+		return n + 5 + len(strconv.Itoa(len(m.value))) + len(m.value)
+	}
+	return n + 3 + len(m.value)
+}
+
+// isCommand checks whether msg is an array of bulk strings, as requests
+// are sent by clients.
+func (m *redisMsg) isCommand() bool {
+	if m.typ != respArray || len(m.elems) == 0 {
+		return false
+	}
+	for _, e := range m.elems {
+		if e.typ != respString || e.isNil {
+			return false
+		}
+	}
+	return true
+}
+
+// cmd returns command name and key of a request, empty if not exist.
+func (m *redisMsg) cmd() (string, string) {
+	var cmd, key string
+	if !m.isCommand() {
+		return cmd, key
+	}
+	cmd = string(m.elems[0].value)
+	if len(m.elems) > 1 {
+		key = string(m.elems[1].value)
+	}
+	return cmd, key
+}
+
+// reply returns the reply used for matching, simple types keep their type
+// byte as on wire, eg: +OK, -ERR unknown command, :1
+func (m *redisMsg) reply() string {
+	switch m.typ {
+	case respOK, respERROR, respInt, respDouble, respBool, respBigNumber:
+		return string(m.typ) + string(m.value)
+	case respBlobError:
+		return string(respERROR) + string(m.value)
+	}
+	return string(m.Bytes())
+}
+
+// String shows replies like redis-cli.
+func (m *redisMsg) String() string {
+	return m.format("")
+}
+
+// command shows requests as args separated by space, eg: set a "hello world",
+// replies with the same shape are shown by String.
+func (m *redisMsg) command() string {
+	if !m.isCommand() {
+		return m.String()
+	}
+	args := make([]string, len(m.elems))
+	for i, e := range m.elems {
+		args[i] = quoteArg(e.value)
+	}
+	return strings.Join(args, " ")
+}
+
+// format renders reply like redis-cli, lines of nested aggregate types are
+// indented to align with the first element.
+func (m *redisMsg) format(indent string) string {
+	if m.attrs == nil {
+		return m.formatValue(indent)
+	}
+	prefix := "(attribute) "
+	return prefix + m.attrs.format(indent+strings.Repeat(" ", len(prefix))) + "\n" + indent + m.formatValue(indent)
+}
+
+func (m *redisMsg) formatValue(indent string) string {
+	if m.isNil {
+		return "(nil)"
+	}
+	switch m.typ {
+	case respOK:
+		return string(m.value)
+	case respERROR, respBlobError:
+		return "(error) " + string(m.value)
+	case respInt:
+		return "(integer) " + string(m.value)
+	case respString:
+		return quote(m.value)
+	case respDouble:
+		return "(double) " + string(m.value)
+	case respBool:
+		if string(m.value) == "t" {
+			return "(true)"
+		}
+		return "(false)"
+	case respBigNumber:
+		return "(big number) " + string(m.value)
+	case respVerbatim:
+		// skip format, eg: txt:
+		return string(m.value[4:])
+	case respMap, respAttribute:
+		return m.formatMap(indent)
+	}
+	// array, set and push
+	if len(m.elems) == 0 {
+		if m.typ == respSet {
+			return "(empty set)"
+		}
+		return "(empty array)"
+	}
+	mark := ")"
+	if m.typ == respSet {
+		mark = "~"
+	}
+	width := len(strconv.Itoa(len(m.elems)))
+	lines := make([]string, len(m.elems))
+	for i, e := range m.elems {
+		prefix := fmt.Sprintf("%*d%s ", width, i+1, mark)
+		lines[i] = prefix + e.format(indent+strings.Repeat(" ", len(prefix)))
+		if i > 0 {
+			lines[i] = indent + lines[i]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatMap renders pairs, eg: 1# "key" => "value"
+func (m *redisMsg) formatMap(indent string) string {
+	if len(m.elems) == 0 {
+		return "(empty hash)"
+	}
+	n := len(m.elems) / 2
+	width := len(strconv.Itoa(n))
+	lines := make([]string, n)
+	for i := 0; i < n; i++ {
+		prefix := fmt.Sprintf("%*d# ", width, i+1)
+		key := m.elems[2*i].format(indent + strings.Repeat(" ", len(prefix)))
+		prefix += key + " => "
+		lines[i] = prefix + m.elems[2*i+1].format(indent+strings.Repeat(" ", len(prefix)))
+		if i > 0 {
+			lines[i] = indent + lines[i]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// quote escapes binary data like redis-cli, eg: "a\r\n\x00"
+func quote(data []byte) string {
+	var buf strings.Builder
+	buf.WriteByte('"')
+	for _, c := range data {
+		switch c {
+		case '\\', '"':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case '\n':
+			buf.WriteString("\\n")
+		case '\r':
+			buf.WriteString("\\r")
+		case '\t':
+			buf.WriteString("\\t")
+		case '\a':
+			buf.WriteString("\\a")
+		case '\b':
+			buf.WriteString("\\b")
+		default:
+			if c < 0x20 || c > 0x7e {
+				fmt.Fprintf(&buf, "\\x%02x", c)
+			} else {
+				buf.WriteByte(c)
+			}
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+// quoteArg quotes command arg only if it's empty or contains space, quote or
+// binary data.
+func quoteArg(data []byte) string {
+	if len(data) == 0 {
+		return `""`
+	}
+	for _, c := range data {
+		if c <= ' ' || c > 0x7e || c == '"' || c == '\'' || c == '\\' {
+			return quote(data)
+		}
+	}
+	return string(data)
+}
 
 type Decoder struct {
-	buf *bufio.Reader
+	buf     *bufio.Reader
+	filter  *Filter
+	session session
+	// show hash slots after commands
+	showSlots bool
 }
 
-func (d *Decoder) Decode(reader io.Reader, writer io.Writer, opts *decoder.Options) error {
+func (d *Decoder) Decode(reader io.Reader, writer decoder.MessageWriter, opts *decoder.Options) error {
 	d.buf = bufio.NewReader(reader)
+	direction := decoder.Response
+	if opts.IsRequest {
+		direction = decoder.Request
+	}
 	for {
-		if result, err := d.decodeRedisMsg(); err != nil {
+		// EOF between msgs is normal end of stream
+		b, err := d.buf.Peek(1)
+		if err != nil {
 			return err
+		}
+		var result *redisMsg
+		if opts.IsRequest && b[0] != respArray {
+			result, err = d.decodeInline()
+		} else {
+			result, err = d.decodeRedisMsg()
+		}
+		if err == nil && result == nil {
+			// empty inline command
+			continue
+		}
+		if err != nil {
+			if !decoder.IsMalformed(err) {
+				return decoder.Truncated(err)
+			}
+			decoder.ReportError(writer, err)
+			if err := d.resync(opts.IsRequest); err != nil {
+				return err
+			}
+			continue
+		}
+		body := result.String()
+		if opts.IsRequest {
+			body = result.command()
+		}
+		msg := &decoder.Message{
+			Protocol:  "redis",
+			Direction: direction,
+			Body:      body,
+			Text:      body + "\n",
+			Decoded:   result,
+		}
+		if direction == decoder.Request {
+			if cmd, key := result.cmd(); cmd != "" {
+				msg.Fields = map[string]interface{}{"cmd": cmd, "key": key}
+			}
+			d.session.trackRequest(result, msg)
+			d.trackSlots(result, msg)
 		} else {
-			writer.Write(result)
-			writer.Write([]byte("\n"))
+			d.session.trackResponse(result, msg)
+			trackCluster(result, msg)
+		}
+		// unmatched msg is still written, so the paired response/request
+		// can be skipped too
+		msg.Skip = msg.Skip || !d.filter.Match(direction, result)
+		if err := writer.WriteMessage(msg); err != nil {
+			return err
 		}
 	}
-	return nil
 }
 
-func (d *Decoder) SetFilter(filter string) {
+// trackSlots adds hash slots of keys to fields, and shows them after the
+// command if slots option is set, eg: get a (slot 15495)
+func (d *Decoder) trackSlots(result *redisMsg, msg *decoder.Message) {
+	slots := result.keySlots()
+	if len(slots) == 0 {
+		return
+	}
+	msg.Fields["slot"] = slots[0]
+	if len(slots) > 1 {
+		msg.Fields["slots"] = slots
+	}
+	if !d.showSlots {
+		return
+	}
+	s := make([]string, len(slots))
+	for i, slot := range slots {
+		s[i] = strconv.Itoa(slot)
+	}
+	label := "slot"
+	if len(slots) > 1 {
+		label = "slots"
+	}
+	msg.Text = fmt.Sprintf("%s (%s %s)\n", msg.Body, label, strings.Join(s, ","))
+}
+
+// trackCluster adds fields of -MOVED/-ASK redirections, and shows replies of
+// CLUSTER SLOTS and CLUSTER SHARDS as one line per slot range.
+func trackCluster(result *redisMsg, msg *decoder.Message) {
+	if kind, slot, node := result.redirect(); kind != "" {
+		msg.Fields = map[string]interface{}{"redirect": kind, "slot": slot, "node": node}
+		return
+	}
+	if msg.Direction != decoder.Response {
+		return
+	}
+	kind := "cluster slots"
+	body, ok := result.formatClusterSlots()
+	if !ok {
+		kind = "cluster shards"
+		if body, ok = result.formatClusterShards(); !ok {
+			return
+		}
+	}
+	msg.Body, msg.Text = body, body+"\n"
+	msg.Fields = map[string]interface{}{"kind": kind}
+}
+
+// resync skips lines until one looks like start of a msg, requests are
+// arrays or inline commands.
+func (d *Decoder) resync(isRequest bool) error {
+	for {
+		b, err := d.buf.Peek(1)
+		if err != nil {
+			return err
+		}
+		if b[0] == respArray || isRequest && isInlineStart(b[0]) || !isRequest && IsRespType(b[0]) {
+			return nil
+		}
+		if _, err := d.buf.ReadBytes('\n'); err != nil {
+			return err
+		}
+	}
+}
 
+func (d *Decoder) SetFilter(filter string) {
+	d.filter = NewFilter(filter)
 }
 
-func (d *Decoder) decodeRedisMsg() ([]byte, error) {
+// readLine reads a line without ending \r\n
+func (d *Decoder) readLine() ([]byte, error) {
 	line, err := d.buf.ReadBytes('\n')
 	if err != nil {
-		return []byte{}, err
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, decoder.Malformed("line without \\r\\n")
+	}
+	return line[:len(line)-2], nil
+}
+
+func (d *Decoder) decodeRedisMsg() (*redisMsg, error) {
+	line, err := d.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, decoder.Malformed("empty line")
 	}
-	line = line[:len(line)-2] // truncate end \r\n
 	headerByte, resp := line[0], line[1:]
+	msg := &redisMsg{typ: headerByte}
 	switch headerByte {
-	case respOK:
-		return resp, nil
-	case respERROR:
-		return resp, nil
-	case respInt:
-		return resp, nil
-	case respString:
+	case respOK, respERROR, respInt, respDouble, respBigNumber:
+		msg.value = resp
+	case respBool:
+		if string(resp) != "t" && string(resp) != "f" {
+			return nil, decoder.Malformed("bad boolean " + strconv.Quote(string(resp)))
+		}
+		msg.value = resp
+	case respNull:
+		msg.isNil = true
+	case respString, respBlobError, respVerbatim:
 		strLen, err := parseLen(resp)
 		if err != nil {
 			return nil, err
 		}
-		if strLen == -1 {
-			return NIL, nil
+		if strLen == -1 && headerByte == respString {
+			msg.isNil = true
+			return msg, nil
+		}
+		if strLen < 0 || strLen > maxBulkLen {
+			return nil, decoder.Malformed("bad bulk string length")
+		}
+		// binary safe, read by declared length, buffer grows as data
+		// arrives, so a garbage length doesn't allocate it at once
+		var buf bytes.Buffer
+		if _, err := io.CopyN(&buf, d.buf, int64(strLen)+2); err != nil {
+			return nil, err
+		}
+		data := buf.Bytes()
+		if !bytes.HasSuffix(data, []byte("\r\n")) {
+			return nil, decoder.Malformed("bulk string length mismatch")
 		}
-		line, _ = d.buf.ReadBytes('\n')
-		return line[:len(line)-2], nil
-	case respArray:
-		arrayLen, err := parseLen(resp)
+		msg.value = data[:strLen]
+		// verbatim string starts with format, eg: txt:
+		if headerByte == respVerbatim && (strLen < 4 || msg.value[3] != ':') {
+			return nil, decoder.Malformed("bad verbatim string")
+		}
+	case respArray, respSet, respPush, respMap, respAttribute:
+		n, err := parseLen(resp)
 		if err != nil {
 			return nil, err
 		}
-		if arrayLen == -1 {
-			// empty array
-			return NIL, nil
+		if n == -1 && headerByte == respArray {
+			msg.isNil = true
+			return msg, nil
+		}
+		if n < 0 {
+			return nil, decoder.Malformed("bad aggregate length")
 		}
-		result := []byte{}
-		for i := 0; i < arrayLen; i++ {
-			tmp, _ := d.decodeRedisMsg()
-			result = append(result, tmp...)
-			if i < arrayLen-1 {
-				result = append(result, byte(' '))
+		if headerByte == respMap || headerByte == respAttribute {
+			n *= 2
+		}
+		for i := 0; i < n; i++ {
+			elem, err := d.decodeRedisMsg()
+			if err != nil {
+				return nil, err
+			}
+			msg.elems = append(msg.elems, elem)
+		}
+		if headerByte == respAttribute {
+			// attribute is followed by the actual reply
+			reply, err := d.decodeRedisMsg()
+			if err != nil {
+				return nil, err
 			}
+			reply.attrs = msg
+			return reply, nil
 		}
-		return result, nil
 	default:
-		return nil, nil
+		return nil, decoder.Malformed("unknown type " + strconv.Quote(string(headerByte)))
 	}
-	return nil, nil
+	return msg, nil
 }
 
 func parseLen(p []byte) (int, error) {
 	if len(p) == 0 {
-		return -1, errors.New("malformed length")
+		return -1, decoder.Malformed("empty length")
 	}
 
 	if p[0] == '-' && len(p) == 2 && p[1] == '1' {
@@ -100,7 +534,7 @@ func parseLen(p []byte) (int, error) {
 	for _, b := range p {
 		n *= 10
 		if b < '0' || b > '9' {
-			return -1, errors.New("illegal bytes in length")
+			return -1, decoder.Malformed("illegal bytes in length")
 		}
 		n += int(b - '0')
 	}
@@ -109,5 +543,20 @@ func parseLen(p []byte) (int, error) {
 }
 
 func init() {
-	decoder.Register("redis", new(Decoder))
+	decoder.Register(&decoder.Info{
+		Name:        "redis",
+		Description: "redis RESP protocol, filter by cmd/key/reply/slot",
+		Args:        map[string]string{"slots": "show cluster hash slots of keys after commands, eg: slots=true"},
+		New: func(args map[string]string) (decoder.Decoder, error) {
+			d := new(Decoder)
+			if s, ok := args["slots"]; ok {
+				show, err := strconv.ParseBool(s)
+				if err != nil {
+					return nil, errors.New("bad slots for redis decoder: " + s)
+				}
+				d.showSlots = show
+			}
+			return d, nil
+		},
+	})
 }
@@ -1,32 +1,41 @@
 package redis
 
 import (
-	"bufio"
 	"bytes"
 	dp "github.com/monsterxx03/pipe/decoder"
 	"io"
+	"runtime"
 	"testing"
 )
 
+type msgCollector []*dp.Message
+
+func (c *msgCollector) WriteMessage(msg *dp.Message) error {
+	*c = append(*c, msg)
+	return nil
+}
+
 func checkRedisCmd(t *testing.T, data []byte, expected string) {
+	checkRedisMsg(t, data, false, expected)
+}
+
+func checkRedisRequest(t *testing.T, data []byte, expected string) {
+	checkRedisMsg(t, data, true, expected)
+}
+
+func checkRedisMsg(t *testing.T, data []byte, isRequest bool, expected string) {
 	decoder := Decoder{}
 	decoder.SetFilter("")
-	pr, pw := io.Pipe()
-	go func() {
-		err := decoder.Decode(bytes.NewReader(data), pw, new(dp.Options))
-		if err != nil && err != io.EOF {
-			t.Error(err)
-		}
-	}()
-
-	bufPr := bufio.NewReader(pr)
-	_data, err := bufPr.ReadString('\n')
-	if err != nil {
+	var msgs msgCollector
+	err := decoder.Decode(bytes.NewReader(data), &msgs, &dp.Options{IsRequest: isRequest})
+	if err != nil && err != io.EOF {
 		t.Error(err)
 	}
-	_data = _data[:len(_data)-1]
-	if string(_data) != expected {
-		t.Error("not equal")
+	if len(msgs) == 0 {
+		t.Fatal("no msg decoded")
+	}
+	if msgs[0].Body != expected {
+		t.Errorf("result: %s\n no match expected: %s", msgs[0].Body, expected)
 	}
 }
 
@@ -35,18 +44,209 @@ func TestDecodeRedisMsgOK(t *testing.T) {
 }
 
 func TestDecodeRedisMsgERROR(t *testing.T) {
-	checkRedisCmd(t, []byte("-ERROR\r\n"), "ERROR")
+	checkRedisCmd(t, []byte("-ERROR\r\n"), "(error) ERROR")
 }
 
 func TestDecodeRedisMsgInt(t *testing.T) {
-	checkRedisCmd(t, []byte(":101\r\n"), "101")
+	checkRedisCmd(t, []byte(":101\r\n"), "(integer) 101")
 }
 
 func TestDecodeRedisMsgString(t *testing.T) {
-	checkRedisCmd(t, []byte("$3\r\nget\r\n"), "get")
-	checkRedisCmd(t, []byte("$-1\r\n"), "nil")
+	checkRedisCmd(t, []byte("$3\r\nget\r\n"), `"get"`)
+	checkRedisCmd(t, []byte("$-1\r\n"), "(nil)")
 }
 
 func TestDecodeRedisMsgArray(t *testing.T) {
-	checkRedisCmd(t, []byte("*2\r\n$3\r\nget\r\n$1\r\na\r\n"), "get a")
+	checkRedisRequest(t, []byte("*2\r\n$3\r\nget\r\n$1\r\na\r\n"), "get a")
+	checkRedisRequest(t, []byte("*3\r\n$3\r\nset\r\n$1\r\na\r\n$3\r\nb c\r\n"), `set a "b c"`)
+	// reply of MGET is shown like redis-cli, even if it looks like a command
+	checkRedisCmd(t, []byte("*2\r\n$1\r\na\r\n$1\r\nb\r\n"), "1) \"a\"\n2) \"b\"")
+	checkRedisCmd(t, []byte("*0\r\n"), "(empty array)")
+	checkRedisCmd(t, []byte("*-1\r\n"), "(nil)")
+}
+
+func TestDecodeRedisMsgNestedArray(t *testing.T) {
+	// eg: reply of EXEC
+	data := "*12\r\n+OK\r\n:1\r\n*2\r\n$1\r\na\r\n*1\r\n$-1\r\n-ERR wrong\r\n" +
+		"$1\r\nb\r\n$1\r\nc\r\n$1\r\nd\r\n$1\r\ne\r\n$1\r\nf\r\n$1\r\ng\r\n*0\r\n$1\r\nh\r\n"
+	checkRedisCmd(t, []byte(data), ` 1) OK
+ 2) (integer) 1
+ 3) 1) "a"
+    2) 1) (nil)
+ 4) (error) ERR wrong
+ 5) "b"
+ 6) "c"
+ 7) "d"
+ 8) "e"
+ 9) "f"
+10) "g"
+11) (empty array)
+12) "h"`)
+}
+
+func decodeWithFilter(t *testing.T, filter string, isRequest bool, data string) msgCollector {
+	decoder := Decoder{}
+	decoder.SetFilter(filter)
+	var msgs msgCollector
+	err := decoder.Decode(bytes.NewReader([]byte(data)), &msgs, &dp.Options{IsRequest: isRequest})
+	if err != nil && err != io.EOF {
+		t.Error(err)
+	}
+	return msgs
+}
+
+func TestRedisFilterRequest(t *testing.T) {
+	data := "*3\r\n$3\r\nSET\r\n$9\r\nsession:1\r\n$1\r\nv\r\n" +
+		"*3\r\n$3\r\nset\r\n$6\r\nuser:1\r\n$1\r\nv\r\n" +
+		"*2\r\n$3\r\nGET\r\n$9\r\nsession:1\r\n" +
+		"*2\r\n$3\r\nDEL\r\n$9\r\nsession:2\r\n"
+	msgs := decodeWithFilter(t, "cmd: ^(SET|DEL)$ & key: ^session:", true, data)
+	if len(msgs) != 4 {
+		t.Fatalf("expect 4 msgs, got %d", len(msgs))
+	}
+	for i, skip := range []bool{false, true, true, false} {
+		if msgs[i].Skip != skip {
+			t.Errorf("msg %q skip: %v", msgs[i].Body, msgs[i].Skip)
+		}
+	}
+	if msgs[0].Fields["cmd"] != "SET" || msgs[0].Fields["key"] != "session:1" {
+		t.Errorf("bad fields: %v", msgs[0].Fields)
+	}
+
+	// reply rule doesn't apply to requests
+	msgs = decodeWithFilter(t, "reply: ^-ERR", true, data)
+	if msgs[0].Skip {
+		t.Error("request skipped by reply rule")
+	}
+}
+
+func TestRedisFilterReply(t *testing.T) {
+	data := "+OK\r\n-ERR unknown command 'foo'\r\n:1\r\n$3\r\nbar\r\n"
+	msgs := decodeWithFilter(t, "reply: ^-ERR", false, data)
+	if len(msgs) != 4 {
+		t.Fatalf("expect 4 msgs, got %d", len(msgs))
+	}
+	for i, skip := range []bool{true, false, true, true} {
+		if msgs[i].Skip != skip {
+			t.Errorf("msg %q skip: %v", msgs[i].Body, msgs[i].Skip)
+		}
+	}
+	msgs = decodeWithFilter(t, "reply: ^bar$ & cmd: get", false, data)
+	if !msgs[0].Skip || msgs[3].Skip {
+		t.Error("bulk string reply not matched")
+	}
+}
+
+// errCollector counts reported errors besides msgs
+type errCollector struct {
+	msgCollector
+	errors int
+}
+
+func (c *errCollector) ReportError(err error) {
+	c.errors++
+}
+
+func TestDecodeRedisResync(t *testing.T) {
+	// capture starts in the middle of a request
+	data := "$1\r\n1\r\n*1\r\n$4\r\nping\r\n$5\r\n12345\r\n*2\r\n$3\r\nget\r\n$1\r\nb\r\n"
+	var c errCollector
+	d := Decoder{}
+	d.SetFilter("")
+	err := d.Decode(bytes.NewReader([]byte(data)), &c, &dp.Options{IsRequest: true})
+	if err != io.EOF {
+		t.Error("expect EOF, got:", err)
+	}
+	if len(c.msgCollector) != 2 {
+		t.Fatal("expect 2 msgs, got:", len(c.msgCollector))
+	}
+	if c.msgCollector[0].Body != "ping" || c.msgCollector[1].Body != "get b" {
+		t.Error("bad msgs:", c.msgCollector[0].Body, c.msgCollector[1].Body)
+	}
+	if c.errors != 2 {
+		t.Error("expect 2 errors, got:", c.errors)
+	}
+}
+
+func TestDecodeRedisTruncated(t *testing.T) {
+	var c errCollector
+	d := Decoder{}
+	d.SetFilter("")
+	err := d.Decode(bytes.NewReader([]byte("*2\r\n$3\r\nget\r\n$5\r\nab")), &c, &dp.Options{IsRequest: true})
+	if err != dp.ErrTruncated {
+		t.Error("expect truncated, got:", err)
+	}
+}
+
+func TestDecodeRedisHugeBulkLen(t *testing.T) {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	var c errCollector
+	d := Decoder{}
+	d.SetFilter("")
+	err := d.Decode(bytes.NewReader([]byte("$536870000\r\nab")), &c, new(dp.Options))
+	runtime.ReadMemStats(&after)
+	if err != dp.ErrTruncated {
+		t.Error("expect truncated, got:", err)
+	}
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Error("allocated by declared length:", n)
+	}
+}
+
+func TestDecodeRedisBinaryBulk(t *testing.T) {
+	checkRedisCmd(t, []byte("$4\r\na\r\nb\r\n"), `"a\r\nb"`)
+	checkRedisCmd(t, []byte("$3\r\n\x00\xff\"\r\n"), `"\x00\xff\""`)
+}
+
+func TestDecodeRedisMsgResp3(t *testing.T) {
+	checkRedisCmd(t, []byte("_\r\n"), "(nil)")
+	checkRedisCmd(t, []byte(",3.14\r\n"), "(double) 3.14")
+	checkRedisCmd(t, []byte("#t\r\n"), "(true)")
+	checkRedisCmd(t, []byte("#f\r\n"), "(false)")
+	checkRedisCmd(t, []byte("(3492890328409238509324850943850943825024385\r\n"),
+		"(big number) 3492890328409238509324850943850943825024385")
+	checkRedisCmd(t, []byte("!21\r\nSYNTAX invalid syntax\r\n"), "(error) SYNTAX invalid syntax")
+	checkRedisCmd(t, []byte("=15\r\ntxt:Some string\r\n"), "Some string")
+	checkRedisCmd(t, []byte("~2\r\n+a\r\n:1\r\n"), "1~ a\n2~ (integer) 1")
+	checkRedisCmd(t, []byte("%0\r\n"), "(empty hash)")
+	checkRedisCmd(t, []byte("%2\r\n+first\r\n:1\r\n$6\r\nsecond\r\n*2\r\n:2\r\n:3\r\n"),
+		"1# first => (integer) 1\n"+
+			"2# \"second\" => 1) (integer) 2\n"+
+			"               2) (integer) 3")
+	checkRedisCmd(t, []byte(">3\r\n$7\r\nmessage\r\n$2\r\nch\r\n$2\r\nhi\r\n"),
+		"1) \"message\"\n2) \"ch\"\n3) \"hi\"")
+	checkRedisCmd(t, []byte("|1\r\n+ttl\r\n:3600\r\n$1\r\nv\r\n"),
+		"(attribute) 1# ttl => (integer) 3600\n\"v\"")
+}
+
+func TestRedisFilterResp3Reply(t *testing.T) {
+	msgs := decodeWithFilter(t, "reply: ^-SYNTAX", false, "!21\r\nSYNTAX invalid syntax\r\n#t\r\n")
+	if len(msgs) != 2 || msgs[0].Skip || !msgs[1].Skip {
+		t.Error("blob error not matched as error reply")
+	}
+}
+
+func TestDecodeRedisInline(t *testing.T) {
+	data := "PING\r\n\r\nset a \"hello world\\x00\\n\" 'it\\'s' \"\"\nget a\r\n"
+	msgs := decodeWithFilter(t, "cmd: ^set$", true, data)
+	if len(msgs) != 3 {
+		t.Fatalf("expect 3 msgs, got %d", len(msgs))
+	}
+	for i, expected := range []string{"PING", `set a "hello world\x00\n" "it's" ""`, "get a"} {
+		if msgs[i].Body != expected {
+			t.Errorf("result: %s\n no match expected: %s", msgs[i].Body, expected)
+		}
+	}
+	if msgs[1].Skip || !msgs[2].Skip || msgs[1].Fields["key"] != "a" {
+		t.Error("inline command not filtered")
+	}
+
+	var c errCollector
+	d := Decoder{}
+	d.SetFilter("")
+	d.Decode(bytes.NewReader([]byte("set a \"b\r\nping\r\n")), &c, &dp.Options{IsRequest: true})
+	if c.errors != 1 || len(c.msgCollector) != 1 || c.msgCollector[0].Body != "ping" {
+		t.Error("unbalanced quotes not reported")
+	}
 }
@@ -0,0 +1,143 @@
+package redis
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/monsterxx03/pipe/decoder"
+)
+
+// commands block until data is available or timeout, their latency is not
+// server latency
+var blockingCmds = map[string]bool{
+	"blpop": true, "brpop": true, "brpoplpush": true, "blmove": true, "blmpop": true,
+	"bzpopmin": true, "bzpopmax": true, "bzmpop": true, "wait": true, "waitaof": true,
+}
+
+// confirmed by pushes instead of replies, one for every channel
+var subscribeCmds = map[string]bool{
+	"subscribe": true, "psubscribe": true, "ssubscribe": true,
+	"unsubscribe": true, "punsubscribe": true, "sunsubscribe": true,
+}
+
+// eg: 1339518083.107412 [0 127.0.0.1:60866] "keys" "*"
+var monitorLine = regexp.MustCompile(`^\d+\.\d+ \[\d+ `)
+
+// session tracks state of one direction of a redis connection, both
+// directions can be tracked alone: requests tell transactions, replies tell
+// pub/sub and MONITOR mode.
+type session struct {
+	multi      bool
+	queued     []*redisMsg
+	subscribed bool
+}
+
+// trackRequest groups commands queued by MULTI into EXEC, and marks
+// subscribe and blocking commands.
+func (s *session) trackRequest(result *redisMsg, msg *decoder.Message) {
+	cmd, _ := result.cmd()
+	cmd = strings.ToLower(cmd)
+	if cmd == "" {
+		return
+	}
+	switch {
+	case cmd == "multi":
+		s.multi, s.queued = true, nil
+	case cmd == "exec" && s.multi:
+		cmds := make([]string, len(s.queued))
+		width := len(strconv.Itoa(len(s.queued)))
+		for i, c := range s.queued {
+			cmds[i] = c.command()
+			msg.Body += fmt.Sprintf("\n%*d) %s", width, i+1, cmds[i])
+		}
+		msg.Fields["commands"] = cmds
+		msg.Text = msg.Body + "\n"
+		result.queued = s.queued
+		s.multi, s.queued = false, nil
+	case cmd == "discard":
+		s.multi, s.queued = false, nil
+	case s.multi:
+		// replied by +QUEUED
+		s.queued = append(s.queued, result)
+		msg.Fields["queued"] = true
+		return
+	}
+	if subscribeCmds[cmd] {
+		msg.NoReply = true
+	}
+	if blockingCmds[cmd] || isBlockingRead(cmd, result) {
+		msg.Fields["blocking"] = true
+	}
+}
+
+// XREAD and XREADGROUP block with BLOCK option
+func isBlockingRead(cmd string, result *redisMsg) bool {
+	if cmd != "xread" && cmd != "xreadgroup" {
+		return false
+	}
+	for _, e := range result.elems[1:] {
+		if strings.EqualFold(string(e.value), "block") {
+			return true
+		}
+		if strings.EqualFold(string(e.value), "streams") {
+			break
+		}
+	}
+	return false
+}
+
+// trackResponse marks msgs pushed by server: pub/sub msgs, subscribe
+// confirmations, resp3 pushes and MONITOR output. +QUEUED is skipped, queued
+// commands are shown with their replies in EXEC.
+func (s *session) trackResponse(result *redisMsg, msg *decoder.Message) {
+	if result.typ == respOK && string(result.value) == "QUEUED" {
+		msg.Skip = true
+		return
+	}
+	if result.typ == respOK && monitorLine.Match(result.value) {
+		msg.Direction = decoder.Push
+		msg.Fields = map[string]interface{}{"kind": "monitor"}
+		return
+	}
+	if (result.typ != respArray && result.typ != respPush) || len(result.elems) == 0 {
+		return
+	}
+	first := result.elems[0]
+	if first.typ != respString && first.typ != respOK {
+		return
+	}
+	kind := strings.ToLower(string(first.value))
+	switch {
+	case subscribeCmds[kind] && len(result.elems) == 3 && result.elems[2].typ == respInt:
+		count, _ := strconv.Atoi(string(result.elems[2].value))
+		s.subscribed = count > 0
+	case (kind == "message" || kind == "smessage") && len(result.elems) == 3 && s.subscribed:
+	case kind == "pmessage" && len(result.elems) == 4 && s.subscribed:
+	case result.typ == respPush:
+	default:
+		return
+	}
+	msg.Direction = decoder.Push
+	msg.Fields = map[string]interface{}{"kind": kind}
+	if len(result.elems) > 1 {
+		msg.Fields["channel"] = string(result.elems[1].value)
+	}
+}
+
+// Merge shows every command queued by MULTI with its reply in EXEC reply.
+func (m *redisMsg) Merge(req, resp *decoder.Message) {
+	reply, ok := resp.Decoded.(*redisMsg)
+	if !ok || m.queued == nil || reply.typ != respArray || len(reply.elems) != len(m.queued) {
+		return
+	}
+	width := len(strconv.Itoa(len(m.queued)))
+	indent := strings.Repeat(" ", width+2)
+	lines := make([]string, len(m.queued))
+	for i, cmd := range m.queued {
+		lines[i] = fmt.Sprintf("%*d) %s\n%s%s", width, i+1, cmd.command(), indent, reply.elems[i].format(indent))
+	}
+	req.Text = m.command() + "\n"
+	resp.Text = strings.Join(lines, "\n") + "\n"
+}
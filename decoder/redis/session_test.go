@@ -0,0 +1,89 @@
+package redis
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+
+	dp "github.com/monsterxx03/pipe/decoder"
+)
+
+func bulks(args ...string) string {
+	data := "*" + strconv.Itoa(len(args)) + "\r\n"
+	for _, arg := range args {
+		data += "$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n"
+	}
+	return data
+}
+
+func TestSessionTransaction(t *testing.T) {
+	data := bulks("multi") + bulks("set", "a", "1") + bulks("incr", "b") + bulks("exec") +
+		bulks("get", "a")
+	msgs := decodeWithFilter(t, "", true, data)
+	if len(msgs) != 5 {
+		t.Fatalf("expect 5 msgs, got %d", len(msgs))
+	}
+	if msgs[1].Fields["queued"] != true || msgs[2].Fields["queued"] != true || msgs[4].Fields["queued"] != nil {
+		t.Error("queued commands not marked")
+	}
+	if msgs[3].Text != "exec\n1) set a 1\n2) incr b\n" {
+		t.Errorf("bad exec: %q", msgs[3].Text)
+	}
+}
+
+func TestSessionExecPairing(t *testing.T) {
+	reqs := decodeWithFilter(t, "", true, bulks("multi")+bulks("set", "a", "1")+bulks("lrange", "l", "0", "-1")+bulks("exec"))
+	resps := decodeWithFilter(t, "", false, "+OK\r\n+QUEUED\r\n+QUEUED\r\n*2\r\n+OK\r\n*2\r\n$1\r\nx\r\n$1\r\ny\r\n")
+	var out bytes.Buffer
+	printer, _ := dp.NewPrinter("text", &out)
+	pairing := dp.NewPairing(printer)
+	for i := range reqs {
+		pairing.Add(reqs[i])
+		pairing.Add(resps[i])
+	}
+	expected := "multi\nOK\n(0s)\nexec\n1) set a 1\n   OK\n2) lrange l 0 -1\n   1) \"x\"\n   2) \"y\"\n(0s)\n"
+	if out.String() != expected {
+		t.Errorf("result: %q\n no match expected: %q", out.String(), expected)
+	}
+}
+
+func TestSessionSubscribe(t *testing.T) {
+	msgs := decodeWithFilter(t, "", true, bulks("subscribe", "a", "b")+bulks("ping"))
+	if !msgs[0].NoReply || msgs[1].NoReply {
+		t.Error("subscribe not marked as no reply")
+	}
+
+	data := "*3\r\n$9\r\nsubscribe\r\n$1\r\na\r\n:1\r\n" +
+		"*3\r\n$9\r\nsubscribe\r\n$1\r\nb\r\n:2\r\n" +
+		"*3\r\n$7\r\nmessage\r\n$1\r\na\r\n$2\r\nhi\r\n" +
+		"*2\r\n$4\r\npong\r\n$0\r\n\r\n" +
+		"*3\r\n$11\r\nunsubscribe\r\n$1\r\na\r\n:0\r\n" +
+		"*3\r\n$7\r\nmessage\r\n$1\r\na\r\n$2\r\nhi\r\n"
+	msgs = decodeWithFilter(t, "", false, data)
+	for i, d := range []dp.Direction{dp.Push, dp.Push, dp.Push, dp.Response, dp.Push, dp.Response} {
+		if msgs[i].Direction != d {
+			t.Errorf("msg %d %q direction: %s", i, msgs[i].Body, msgs[i].Direction)
+		}
+	}
+	if msgs[2].Fields["kind"] != "message" || msgs[2].Fields["channel"] != "a" {
+		t.Errorf("bad fields: %v", msgs[2].Fields)
+	}
+	// resp3 push
+	msgs = decodeWithFilter(t, "", false, ">2\r\n$10\r\ninvalidate\r\n*1\r\n$1\r\na\r\n")
+	if msgs[0].Direction != dp.Push {
+		t.Error("resp3 push not marked")
+	}
+}
+
+func TestSessionBlockingAndMonitor(t *testing.T) {
+	msgs := decodeWithFilter(t, "", true, bulks("blpop", "q", "0")+
+		bulks("xread", "block", "0", "streams", "s", "$")+bulks("xread", "streams", "block", "0"))
+	if msgs[0].Fields["blocking"] != true || msgs[1].Fields["blocking"] != true || msgs[2].Fields["blocking"] != nil {
+		t.Error("blocking commands not marked")
+	}
+	data := "+OK\r\n+1339518083.107412 [0 127.0.0.1:60866] \"keys\" \"*\"\r\n"
+	msgs = decodeWithFilter(t, "", false, data)
+	if msgs[0].Direction != dp.Response || msgs[1].Direction != dp.Push || msgs[1].Fields["kind"] != "monitor" {
+		t.Error("monitor output not marked")
+	}
+}
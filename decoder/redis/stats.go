@@ -0,0 +1,276 @@
+package redis
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/monsterxx03/pipe/decoder"
+)
+
+// StatsPrinter aggregates redis commands instead of printing them, and
+// prints a report like top at the end of every window: commands per second
+// by name, top keys by count and bytes, largest values and error replies.
+// Windows are based on capture time, so it works for pcap files too.
+// Replies are only known with -r.
+type StatsPrinter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	window time.Duration
+	top    int
+	clear  bool // clear screen before report
+
+	start    time.Time
+	last     time.Time
+	cmds     map[string]int
+	keyCount map[string]int
+	keyBytes map[string]int
+	values   map[string]valueSize // largest value of key
+	errors   map[string]int
+}
+
+type valueSize struct {
+	cmd  string
+	size int
+}
+
+// Print counts request, it has no reply without -r.
+func (p *StatsPrinter) Print(msg *decoder.Message) error {
+	if msg.Direction != decoder.Request {
+		return nil
+	}
+	return p.count(&decoder.Transaction{Request: msg}, false)
+}
+
+// PrintTransaction counts paired request and reply, commands queued by
+// MULTI are dropped with their +QUEUED replies, so they're counted with EXEC.
+func (p *StatsPrinter) PrintTransaction(t *decoder.Transaction) error {
+	return p.count(t, true)
+}
+
+func (p *StatsPrinter) count(t *decoder.Transaction, withQueued bool) error {
+	var req, resp *redisMsg
+	var ts time.Time
+	if t.Request != nil {
+		req, _ = t.Request.Decoded.(*redisMsg)
+		ts = t.Request.Timestamp
+	}
+	if t.Response != nil {
+		resp, _ = t.Response.Decoded.(*redisMsg)
+		if ts.IsZero() {
+			ts = t.Response.Timestamp
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.start.IsZero() {
+		p.start = ts
+	}
+	if ts.Sub(p.start) >= p.window {
+		if err := p.report(); err != nil {
+			return err
+		}
+		p.reset(ts)
+	}
+	if ts.After(p.last) {
+		p.last = ts
+	}
+	p.add(req, resp)
+	if withQueued && req != nil {
+		p.addQueued(req, resp)
+	}
+	return nil
+}
+
+// addQueued counts commands queued in EXEC with their replies
+func (p *StatsPrinter) addQueued(exec, resp *redisMsg) {
+	paired := resp != nil && resp.typ == respArray && len(resp.elems) == len(exec.queued)
+	for i, cmd := range exec.queued {
+		var reply *redisMsg
+		if paired {
+			reply = resp.elems[i]
+		}
+		p.add(cmd, reply)
+	}
+}
+
+func (p *StatsPrinter) add(req, resp *redisMsg) {
+	if resp != nil && (resp.typ == respERROR || resp.typ == respBlobError) {
+		p.errors[strings.SplitN(string(resp.value), " ", 2)[0]]++
+	}
+	if req == nil {
+		return
+	}
+	cmd, _ := req.cmd()
+	if cmd == "" {
+		return
+	}
+	cmd = strings.ToLower(cmd)
+	p.cmds[cmd]++
+	keys := req.keys()
+	if len(keys) == 0 {
+		return
+	}
+	// bytes of multi-key commands are shared by keys
+	size := req.size()
+	if resp != nil {
+		size += resp.size()
+	}
+	for _, key := range keys {
+		p.keyCount[string(key)]++
+		p.keyBytes[string(key)] += size / len(keys)
+	}
+	// value written by request, or read by reply
+	for key, n := range req.values() {
+		p.addValue(key, cmd, n)
+	}
+	if resp == nil || resp.typ == respERROR || resp.typ == respBlobError {
+		return
+	}
+	if len(keys) == 1 {
+		p.addValue(string(keys[0]), cmd, resp.valueSize())
+	} else if cmd == "mget" && len(resp.elems) == len(keys) {
+		for i, key := range keys {
+			p.addValue(string(key), cmd, resp.elems[i].valueSize())
+		}
+	}
+}
+
+func (p *StatsPrinter) addValue(key, cmd string, size int) {
+	if size > p.values[key].size {
+		p.values[key] = valueSize{cmd, size}
+	}
+}
+
+// valueSize is length of reply value, aggregate is counted by wire size.
+func (m *redisMsg) valueSize() int {
+	switch {
+	case m.typ == respERROR || m.typ == respBlobError:
+		return 0
+	case m.isAggregate():
+		return m.size()
+	}
+	return len(m.value)
+}
+
+func (p *StatsPrinter) reset(ts time.Time) {
+	p.start, p.last = ts, ts
+	p.cmds = make(map[string]int)
+	p.keyCount = make(map[string]int)
+	p.keyBytes = make(map[string]int)
+	p.values = make(map[string]valueSize)
+	p.errors = make(map[string]int)
+}
+
+// topN returns keys of m sorted by value desc, at most n
+func topN(m map[string]int, n int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if m[keys[i]] != m[keys[j]] {
+			return m[keys[i]] > m[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+func (p *StatsPrinter) report() error {
+	if p.start.IsZero() {
+		return nil
+	}
+	// rate of last window is counted by its real length
+	elapsed := p.window
+	if d := p.last.Sub(p.start); d < elapsed {
+		elapsed = d
+	}
+	if elapsed < time.Second {
+		elapsed = time.Second
+	}
+	total := 0
+	for _, n := range p.cmds {
+		total += n
+	}
+	tw := tabwriter.NewWriter(p.w, 0, 8, 2, ' ', 0)
+	if p.clear {
+		fmt.Fprint(tw, "\033[H\033[2J")
+	}
+	fmt.Fprintf(tw, "redis stats %s - %s, %d commands, %.1f/s\n",
+		p.start.Format("2006-01-02 15:04:05"), p.last.Format("15:04:05"), total, float64(total)/elapsed.Seconds())
+	fmt.Fprintln(tw, "\nCMD\tCOUNT\t/S")
+	for _, cmd := range topN(p.cmds, p.top) {
+		fmt.Fprintf(tw, "%s\t%d\t%.1f\n", cmd, p.cmds[cmd], float64(p.cmds[cmd])/elapsed.Seconds())
+	}
+	fmt.Fprintln(tw, "\nKEY\tCOUNT")
+	for _, key := range topN(p.keyCount, p.top) {
+		fmt.Fprintf(tw, "%s\t%d\n", quoteArg([]byte(key)), p.keyCount[key])
+	}
+	fmt.Fprintln(tw, "\nKEY\tBYTES")
+	for _, key := range topN(p.keyBytes, p.top) {
+		fmt.Fprintf(tw, "%s\t%d\n", quoteArg([]byte(key)), p.keyBytes[key])
+	}
+	sizes := make(map[string]int, len(p.values))
+	for key, v := range p.values {
+		sizes[key] = v.size
+	}
+	fmt.Fprintln(tw, "\nLARGEST VALUE\tCMD\tBYTES")
+	for _, key := range topN(sizes, p.top) {
+		fmt.Fprintf(tw, "%s\t%s\t%d\n", quoteArg([]byte(key)), p.values[key].cmd, sizes[key])
+	}
+	fmt.Fprintln(tw, "\nERROR\tCOUNT")
+	for _, e := range topN(p.errors, p.top) {
+		fmt.Fprintf(tw, "%s\t%d\n", e, p.errors[e])
+	}
+	fmt.Fprintln(tw)
+	return tw.Flush()
+}
+
+// Tick reports the window by wall clock if it ends, so a quiet window is
+// reported in live capture too.
+func (p *StatsPrinter) Tick(now time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.start.IsZero() {
+		p.reset(now)
+		return nil
+	}
+	if now.Sub(p.start) < p.window {
+		return nil
+	}
+	p.last = now
+	if err := p.report(); err != nil {
+		return err
+	}
+	p.reset(now)
+	return nil
+}
+
+// Close prints report of the last window.
+func (p *StatsPrinter) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.report()
+}
+
+// NewStatsPrinter reports every window, top is number of rows in every
+// table. Screen is cleared before report if w is a terminal.
+func NewStatsPrinter(w io.Writer, window time.Duration, top int) *StatsPrinter {
+	p := &StatsPrinter{w: w, window: window, top: top}
+	if f, ok := w.(*os.File); ok {
+		if stat, err := f.Stat(); err == nil && stat.Mode()&os.ModeCharDevice != 0 {
+			p.clear = true
+		}
+	}
+	p.reset(time.Time{})
+	return p
+}
@@ -0,0 +1,110 @@
+package redis
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	dp "github.com/monsterxx03/pipe/decoder"
+)
+
+func decodeMsgs(t *testing.T, data string, isRequest bool) msgCollector {
+	return decodeWithFilter(t, "", isRequest, data)
+}
+
+func TestRedisMsgSize(t *testing.T) {
+	for _, data := range []string{"*2\r\n$3\r\nget\r\n$1\r\na\r\n", "$-1\r\n", "+OK\r\n", ":10\r\n",
+		"%1\r\n+a\r\n$2\r\nbc\r\n", "|1\r\n+ttl\r\n:3600\r\n$1\r\nv\r\n"} {
+		msgs := decodeMsgs(t, data, false)
+		if size := msgs[0].Decoded.(*redisMsg).size(); size != len(data) {
+			t.Errorf("size of %q: %d", data, size)
+		}
+	}
+}
+
+func TestStatsPrinter(t *testing.T) {
+	reqs := decodeMsgs(t, "*3\r\n$3\r\nset\r\n$1\r\na\r\n$5\r\nhello\r\n"+
+		"*2\r\n$3\r\nGET\r\n$1\r\na\r\n*2\r\n$3\r\nget\r\n$1\r\nb\r\n*2\r\n$3\r\nget\r\n$1\r\na\r\n"+
+		"*2\r\n$4\r\nauth\r\n$6\r\ns3cr3t\r\n*3\r\n$4\r\nmget\r\n$1\r\nb\r\n$1\r\nc\r\n", true)
+	resps := decodeMsgs(t, "+OK\r\n$5\r\nhello\r\n-WRONGTYPE Operation\r\n$5\r\nhello\r\n"+
+		"+OK\r\n*2\r\n$2\r\nab\r\n$-1\r\n", false)
+	var buf bytes.Buffer
+	p := NewStatsPrinter(&buf, 10*time.Second, 10)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range reqs {
+		reqs[i].Timestamp = start.Add(time.Duration(i) * time.Second)
+		p.PrintTransaction(&dp.Transaction{Request: reqs[i], Response: resps[i]})
+	}
+	if buf.Len() != 0 {
+		t.Fatal("report printed before window ends")
+	}
+	// first message of next window prints report
+	p.Print(&dp.Message{Direction: dp.Response, Timestamp: start.Add(time.Minute)})
+	if buf.Len() != 0 {
+		t.Fatal("response without request is counted")
+	}
+	p.Print(&dp.Message{Direction: dp.Request, Timestamp: start.Add(time.Minute), Decoded: reqs[2].Decoded})
+	report := buf.String()
+	for _, s := range []string{
+		"6 commands, 1.2/s",
+		"get   3      0.6\n",
+		"set   1      0.2\n",
+		"KEY  COUNT\na    3\nb    2\nc    1\n",
+		"KEY  BYTES\na    98\nb    64\nc    22\n",
+		"a              set   5\nb              mget  2\n",
+		"WRONGTYPE  1\n",
+	} {
+		if !strings.Contains(report, s) {
+			t.Errorf("%q not in report:\n%s", s, report)
+		}
+	}
+	if strings.Contains(report, "s3cr3t") {
+		t.Error("password of AUTH reported as key")
+	}
+	buf.Reset()
+	p.Close()
+	if !strings.Contains(buf.String(), "1 commands") {
+		t.Errorf("last window not reported:\n%s", buf.String())
+	}
+}
+
+func TestStatsPrinterTick(t *testing.T) {
+	var buf bytes.Buffer
+	p := NewStatsPrinter(&buf, 10*time.Second, 10)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	p.Tick(start)
+	p.Tick(start.Add(5 * time.Second))
+	if buf.Len() != 0 {
+		t.Fatal("report printed before window ends")
+	}
+	// quiet window is reported too
+	p.Tick(start.Add(10 * time.Second))
+	if !strings.Contains(buf.String(), "0 commands, 0.0/s") {
+		t.Errorf("quiet window not reported:\n%s", buf.String())
+	}
+}
+
+func TestStatsPrinterMulti(t *testing.T) {
+	reqs := decodeMsgs(t, "*1\r\n$5\r\nmulti\r\n*3\r\n$3\r\nset\r\n$1\r\na\r\n$5\r\nhello\r\n"+
+		"*2\r\n$3\r\nget\r\n$1\r\na\r\n*1\r\n$4\r\nexec\r\n", true)
+	resps := decodeMsgs(t, "+OK\r\n+QUEUED\r\n+QUEUED\r\n*2\r\n+OK\r\n$5\r\nhello\r\n", false)
+	var buf bytes.Buffer
+	p := NewStatsPrinter(&buf, 10*time.Second, 10)
+	pairing := dp.NewPairing(p)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range reqs {
+		reqs[i].Timestamp, resps[i].Timestamp = start, start
+		pairing.Add(reqs[i])
+		pairing.Add(resps[i])
+	}
+	pairing.Close()
+	p.Close()
+	report := buf.String()
+	// queued commands are counted with EXEC
+	for _, s := range []string{"4 commands", "set    1", "get    1", "KEY  COUNT\na    2\n", "a              set  5\n"} {
+		if !strings.Contains(report, s) {
+			t.Errorf("%q not in report:\n%s", s, report)
+		}
+	}
+}
@@ -1,16 +1,31 @@
 package text
 
 import (
-	"github.com/monsterxx03/pipe/decoder"
 	"io"
+	"strconv"
+
+	"github.com/juju/errors"
+	"github.com/monsterxx03/pipe/decoder"
 )
 
 type Decoder struct {
+	size int
 }
 
-func (d *Decoder) Decode(reader io.Reader, writer io.Writer, opts *decoder.Options) error {
-	_, err := io.Copy(writer, reader)
-	return err
+func (d *Decoder) Decode(reader io.Reader, writer decoder.MessageWriter, opts *decoder.Options) error {
+	buf := make([]byte, d.size)
+	for {
+		n, err := reader.Read(buf)
+		if n > 0 {
+			msg := &decoder.Message{Protocol: "text", Body: string(buf[:n]), Text: string(buf[:n])}
+			if err := writer.WriteMessage(msg); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
 }
 
 func (d *Decoder) SetFilter(filter string) {
@@ -18,5 +33,20 @@ func (d *Decoder) SetFilter(filter string) {
 }
 
 func init() {
-	decoder.Register("text", new(Decoder))
+	decoder.Register(&decoder.Info{
+		Name:        "text",
+		Description: "print payload as it is",
+		Args:        map[string]string{"size": "max bytes of one message, default 4096"},
+		New: func(args map[string]string) (decoder.Decoder, error) {
+			d := &Decoder{size: 4096}
+			if s, ok := args["size"]; ok {
+				size, err := strconv.Atoi(s)
+				if err != nil || size <= 0 {
+					return nil, errors.New("bad size for text decoder: " + s)
+				}
+				d.size = size
+			}
+			return d, nil
+		},
+	})
 }
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/juju/errors"
+)
+
+// packetWriter is implemented by both pcap and pcapng writers.
+type packetWriter interface {
+	WritePacket(ci gopacket.CaptureInfo, data []byte) error
+}
+
+// Dumper saves raw packets into pcap or pcapng files (chosen by extension),
+// starting a new file when size or age limit is reached.
+// eg: capture.pcap, capture.1.pcap, capture.2.pcap ...
+type Dumper struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+
+	file    *os.File
+	w       packetWriter
+	ng      bool
+	intfs   map[layers.LinkType]int  // pcapng interface id for link type
+	link    layers.LinkType          // pcap file only support one link type
+	dropped map[layers.LinkType]bool // link types not written into pcap file
+	size    int64
+	created time.Time
+	count   int
+}
+
+func (d *Dumper) WritePacket(linkType layers.LinkType, ci gopacket.CaptureInfo, data []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.file != nil && d.full(ci.Timestamp) {
+		if err := d.close(); err != nil {
+			return err
+		}
+	}
+	if d.file == nil {
+		if err := d.open(linkType, ci.Timestamp); err != nil {
+			return err
+		}
+	}
+	if d.ng {
+		id, ok := d.intfs[linkType]
+		if !ok {
+			var err error
+			if id, err = d.w.(*pcapgo.NgWriter).AddInterface(ngInterface(linkType)); err != nil {
+				return err
+			}
+			d.intfs[linkType] = id
+		}
+		ci.InterfaceIndex = id
+	} else if linkType != d.link {
+		// error is only returned for the first packet, so it's logged once
+		if d.dropped[linkType] {
+			return nil
+		}
+		d.dropped[linkType] = true
+		return errors.Errorf("can't write %s packets into %s pcap file, they're dropped, use .pcapng", linkType, d.link)
+	}
+	if err := d.w.WritePacket(ci, data); err != nil {
+		return err
+	}
+	if ng, ok := d.w.(*pcapgo.NgWriter); ok {
+		// keep file readable while capturing
+		if err := ng.Flush(); err != nil {
+			return err
+		}
+	}
+	d.size += int64(ci.CaptureLength) + 16 // rough record header size
+	return nil
+}
+
+func (d *Dumper) full(ts time.Time) bool {
+	if d.maxSize > 0 && d.size >= d.maxSize {
+		return true
+	}
+	if d.maxAge > 0 && ts.Sub(d.created) >= d.maxAge {
+		return true
+	}
+	return false
+}
+
+func (d *Dumper) open(linkType layers.LinkType, ts time.Time) error {
+	f, err := os.Create(d.filename())
+	if err != nil {
+		return err
+	}
+	if d.ng {
+		w, err := pcapgo.NewNgWriterInterface(f, ngInterface(linkType), pcapgo.DefaultNgWriterOptions)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		d.w = w
+		d.intfs = map[layers.LinkType]int{linkType: 0}
+	} else {
+		w := pcapgo.NewWriterNanos(f)
+		if err := w.WriteFileHeader(65536, linkType); err != nil {
+			f.Close()
+			return err
+		}
+		d.w = w
+		d.link = linkType
+	}
+	d.file = f
+	d.size = 0
+	d.created = ts
+	d.count++
+	return nil
+}
+
+// filename of current file, first one is path itself.
+func (d *Dumper) filename() string {
+	if d.count == 0 {
+		return d.path
+	}
+	ext := filepath.Ext(d.path)
+	return strings.TrimSuffix(d.path, ext) + "." + strconv.Itoa(d.count) + ext
+}
+
+func (d *Dumper) close() error {
+	if ng, ok := d.w.(*pcapgo.NgWriter); ok {
+		if err := ng.Flush(); err != nil {
+			return err
+		}
+	}
+	err := d.file.Close()
+	d.file = nil
+	d.w = nil
+	return err
+}
+
+// Close flushes and closes current file.
+func (d *Dumper) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.file == nil {
+		return nil
+	}
+	return d.close()
+}
+
+func ngInterface(linkType layers.LinkType) pcapgo.NgInterface {
+	intf := pcapgo.DefaultNgInterface
+	intf.Name = linkType.String()
+	intf.LinkType = linkType
+	return intf
+}
+
+// NewDumper creates a dumper, maxSize in bytes and maxAge of 0 mean no limit.
+func NewDumper(path string, maxSize int64, maxAge time.Duration) *Dumper {
+	return &Dumper{
+		path:    path,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+		ng:      strings.EqualFold(filepath.Ext(path), ".pcapng"),
+		dropped: make(map[layers.LinkType]bool),
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+func countPackets(t *testing.T, path string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := pcapgo.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for {
+		if _, _, err := r.ReadPacketData(); err == io.EOF {
+			return n
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		n++
+	}
+}
+
+func TestDumperRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pipe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	data := make([]byte, 100)
+	ci := gopacket.CaptureInfo{Timestamp: now, CaptureLength: len(data), Length: len(data)}
+
+	// rotate by size: 2 packets per file
+	d := NewDumper(filepath.Join(dir, "size.pcap"), 200, 0)
+	for i := 0; i < 5; i++ {
+		if err := d.WritePacket(layers.LinkTypeEthernet, ci, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.Close()
+	assertEqual(t, countPackets(t, filepath.Join(dir, "size.pcap")), 2)
+	assertEqual(t, countPackets(t, filepath.Join(dir, "size.1.pcap")), 2)
+	assertEqual(t, countPackets(t, filepath.Join(dir, "size.2.pcap")), 1)
+
+	// rotate by capture time
+	d = NewDumper(filepath.Join(dir, "time.pcap"), 0, time.Minute)
+	for i := 0; i < 3; i++ {
+		ci.Timestamp = now.Add(time.Duration(i) * 40 * time.Second)
+		if err := d.WritePacket(layers.LinkTypeEthernet, ci, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.Close()
+	assertEqual(t, countPackets(t, filepath.Join(dir, "time.pcap")), 2)
+	assertEqual(t, countPackets(t, filepath.Join(dir, "time.1.pcap")), 1)
+
+	// pcap file only accept one link type
+	d = NewDumper(filepath.Join(dir, "link.pcap"), 0, 0)
+	d.WritePacket(layers.LinkTypeEthernet, ci, data)
+	if err := d.WritePacket(layers.LinkTypeRaw, ci, data); err == nil {
+		t.Error("expect link type error")
+	}
+	// told once, the rest are dropped silently
+	if err := d.WritePacket(layers.LinkTypeRaw, ci, data); err != nil {
+		t.Error("link type error returned again:", err)
+	}
+	d.WritePacket(layers.LinkTypeEthernet, ci, data)
+	d.Close()
+	assertEqual(t, countPackets(t, filepath.Join(dir, "link.pcap")), 2)
+}
+
+func TestDumperPcapng(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pipe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := make([]byte, 100)
+	ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}
+	path := filepath.Join(dir, "out.pcapng")
+	d := NewDumper(path, 0, 0)
+	if err := d.WritePacket(layers.LinkTypeEthernet, ci, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.WritePacket(layers.LinkTypeRaw, ci, data); err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := pcapgo.NewNgReader(f, pcapgo.NgReaderOptions{WantMixedLinkType: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ci1, err := r.ReadPacketData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ci2, err := r.ReadPacketData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, ci1.InterfaceIndex, 0)
+	assertEqual(t, ci2.InterfaceIndex, 1)
+	assertEqual(t, r.NInterfaces(), 2)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// max number of pending writes before mirror gives up
+const mirrorQueueSize = 1024
+
+// mirror connection is dropped if target doesn't read for it
+const mirrorWriteTimeout = 5 * time.Second
+
+// Mirror forwards a captured client stream to shadow target over its own
+// connection in real time, responses from target are discarded. It never
+// blocks capture, if target is too slow or the captured stream has a gap, the
+// mirror connection is dropped, since a stream with a gap is useless for the
+// target.
+type Mirror struct {
+	target string
+	data   chan []byte
+	broken int32
+	done   chan struct{}
+}
+
+// Write queues a copy of data to be sent.
+func (m *Mirror) Write(data []byte) (int, error) {
+	if atomic.LoadInt32(&m.broken) == 1 {
+		return len(data), nil
+	}
+	buf := make([]byte, len(data))
+	copy(buf, data)
+	select {
+	case m.data <- buf:
+	default:
+		if atomic.CompareAndSwapInt32(&m.broken, 0, 1) {
+			log.Println("mirror to", m.target, "is too slow, drop it")
+		}
+	}
+	return len(data), nil
+}
+
+// Gap stops mirroring after data missed by capture.
+func (m *Mirror) Gap() {
+	if atomic.CompareAndSwapInt32(&m.broken, 0, 1) {
+		log.Println("captured stream has a gap, stop mirroring it to", m.target)
+	}
+}
+
+// Close closes mirror connection after all queued data is sent, it waits
+// until then.
+func (m *Mirror) Close() error {
+	close(m.data)
+	<-m.done
+	return nil
+}
+
+func (m *Mirror) run() {
+	defer close(m.done)
+	conn, err := net.DialTimeout("tcp", m.target, 5*time.Second)
+	if err != nil {
+		log.Println("fail to connect mirror target:", err)
+		atomic.StoreInt32(&m.broken, 1)
+		m.drain()
+		return
+	}
+	defer conn.Close()
+	go io.Copy(ioutil.Discard, conn)
+	for data := range m.data {
+		if atomic.LoadInt32(&m.broken) == 1 {
+			break
+		}
+		conn.SetWriteDeadline(time.Now().Add(mirrorWriteTimeout))
+		if _, err := conn.Write(data); err != nil {
+			log.Println("fail to write mirror target:", err)
+			atomic.StoreInt32(&m.broken, 1)
+			break
+		}
+	}
+	m.drain()
+}
+
+func (m *Mirror) drain() {
+	for range m.data {
+	}
+}
+
+// NewMirror connects to target in background.
+func NewMirror(target string) *Mirror {
+	m := &Mirror{target: target, data: make(chan []byte, mirrorQueueSize), done: make(chan struct{})}
+	go m.run()
+	return m
+}
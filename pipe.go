@@ -2,41 +2,121 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/monsterxx03/pipe/decoder"
-	_ "github.com/monsterxx03/pipe/decoder/http"
-	_ "github.com/monsterxx03/pipe/decoder/redis"
+	_ "github.com/monsterxx03/pipe/decoder/auto"
+	"github.com/monsterxx03/pipe/decoder/http"
+	"github.com/monsterxx03/pipe/decoder/redis"
 	_ "github.com/monsterxx03/pipe/decoder/text"
 
 	"github.com/google/gopacket"
-	_ "github.com/google/gopacket/layers"
+	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
+	"github.com/google/gopacket/tcpassembly"
+	"github.com/juju/errors"
 )
 
 var (
-	localPort  = flag.String("p", "80", "Local port to capture traffic")
-	traceResp  = flag.Bool("r", false, "Whether to trace response traffic")
-	decodeAs   = flag.String("d", "text", "parse payload, support decoder: text, redis, http")
-	deepDecode = flag.String("dd", "", "deep decode based on content type, works for http now, if -dd is provided, -d will be ignored")
-	filterStr  = flag.String("f", "", "used to parse msg")
+	localPort    = flag.String("p", "80", "Local ports to capture traffic (remote ports with -out), separated by comma, support range, eg: 6379,7000-7005")
+	traceResp    = flag.Bool("r", false, "Whether to trace response traffic")
+	decodeAs     = flag.String("d", "text", "parse payload, support decoder: text, redis, http, auto, use -d help to list all")
+	deepDecode   = flag.String("dd", "", "deep decode based on content type, works for http now, if -dd is provided, -d will be ignored")
+	decoderOpts  = flag.String("dopt", "", "Decoder specific options separated by comma, eg: -d text -dopt size=1024, see -d help")
+	filterStr    = flag.String("f", "", "used to parse msg")
+	output       = flag.String("o", "text", "output format: text, json, ndjson")
+	pbDesc       = flag.String("pbdesc", "", "protobuf descriptor set file used by -dd http, generated by: protoc --include_imports --descriptor_set_out")
+	pbType       = flag.String("pbtype", "", "protobuf message full name used by -dd http if content type doesn't tell it")
+	pcapFile     = flag.String("file", "", "Read packets from pcap/pcapng file instead of live capture")
+	dumpFile     = flag.String("w", "", "Also write matched packets to pcap file, use .pcapng extension for pcapng format")
+	dumpSize     = flag.Int("wsize", 0, "Rotate -w file after it reaches size in MB, 0 means no limit")
+	dumpTime     = flag.Duration("wtime", 0, "Rotate -w file after duration, eg: 10m, 0 means no limit")
+	mirrorTarget = flag.String("mirror", "", "Mirror captured request streams to host:port, responses from it are discarded")
+	outgoing     = flag.Bool("out", false, "Capture outgoing traffic, local machine is client, -p is port of remote server")
+	interfaces   = flag.String("i", "", "Interfaces to capture, separated by comma, support glob pattern, eg: eth0,lo, capture all interfaces if empty")
+	excludeIntfs = flag.String("xi", "", "Interfaces not to capture, separated by comma, support glob pattern, eg: veth*,docker*")
+	hosts        = flag.String("host", "", "Hosts to capture, separated by comma, replace addresses of interface if provided, filter -file too")
+	extraHosts   = flag.String("addhost", "", "Extra hosts to capture besides addresses of interface, separated by comma, eg: pod ips on node")
+	bpfExpr      = flag.String("bpf", "", "Raw BPF expression used as it is instead of generated one")
+	bpfAndExpr   = flag.String("bpfand", "", "Raw BPF expression ANDed with generated one, eg: 'not host 10.0.0.5'")
+	count        = flag.Int64("c", 0, "Stop after printing N messages, 0 means no limit")
+	duration     = flag.Duration("t", 0, "Stop after duration, eg: 30s, 0 means no limit")
+	recordFile   = flag.String("record", "", "Record captured client streams with timing into session file, play it back by: pipe replay")
+	diffTarget   = flag.String("diff", "", "Send captured http requests to candidate host:port, print differences between its responses and captured ones, need -r")
+	diffHeaders  = flag.String("diffh", "", "Response headers compared by -diff, separated by comma, eg: content-type,etag")
+	diffFields   = flag.String("difff", "", "Json body fields compared by -diff, separated by comma, eg: data.id,code, compare all fields if empty")
+	statsWindow  = flag.Duration("rstats", 10*time.Second, "Report interval of redis-stats mode")
+	redisTop     = flag.Int("rtop", 10, "Number of rows in each table of redis-stats report")
 )
 
+var (
+	stats    = NewStats()
+	stopping = make(chan struct{})
+	stopOnce sync.Once
+)
+
+// stop makes all captures flush and return
+func stop() {
+	stopOnce.Do(func() { close(stopping) })
+}
+
+// handleSignals stops capture on SIGINT/SIGTERM, exits at once on second one.
+// SIGPIPE is caught, so writing to closed stdout returns error instead of
+// killing pipe, then limitPrinter stops it, eg: pipe ... | head
+func handleSignals() {
+	signal.Notify(make(chan os.Signal, 1), syscall.SIGPIPE)
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		log.Println("stopping, send signal again to force exit")
+		stop()
+		<-sigs
+		os.Exit(1)
+	}()
+}
+
 // eg: tcp port 80 and (host addr1 or host add2)
-func buildBPFFilter(traceResp bool, localIps []string, localPort string) string {
+// If outgoing, local machine is client, localPort is port of remote server,
+// requests are sent from local ips.
+func buildBPFFilter(traceResp bool, localIps []string, localPort string, outgoing bool) string {
 	result := "tcp "
-	if traceResp {
-		result += "port " + localPort
+	var portExprs []string
+	for _, port := range strings.Split(localPort, ",") {
+		typ := "port "
+		if strings.Contains(port, "-") {
+			typ = "portrange "
+		}
+		if !traceResp {
+			// only trace requests
+			typ = "dst " + typ
+		}
+		portExprs = append(portExprs, typ+strings.TrimSpace(port))
+	}
+	if len(portExprs) == 1 {
+		result += portExprs[0]
 	} else {
-		// only trace incoming data
-		result += "dst port " + localPort
+		result += "(" + strings.Join(portExprs, " or ") + ")"
+	}
+	if len(localIps) == 0 {
+		return result
 	}
 	var dstHost string
 	for i, ip := range localIps {
 		if traceResp {
 			dstHost += " host " + ip
+		} else if outgoing {
+			dstHost += " src host " + ip
 		} else {
 			dstHost += " dst host " + ip
 		}
@@ -48,6 +128,64 @@ func buildBPFFilter(traceResp bool, localIps []string, localPort string) string
 	return result
 }
 
+// composeBPFFilter applies -bpf and -bpfand to generated filter
+func composeBPFFilter(generated, expr, andExpr string) string {
+	if expr != "" {
+		return expr
+	}
+	if andExpr != "" {
+		return "(" + generated + ") and (" + andExpr + ")"
+	}
+	return generated
+}
+
+type portRange struct {
+	from, to int
+}
+
+// parsePorts parses -p, eg: 6379,6380,7000-7005
+func parsePorts(s string) ([]portRange, error) {
+	var result []portRange
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		parts := strings.SplitN(item, "-", 2)
+		from, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, errors.Errorf("bad port %q", item)
+		}
+		to := from
+		if len(parts) == 2 {
+			if to, err = strconv.Atoi(parts[1]); err != nil {
+				return nil, errors.Errorf("bad port %q", item)
+			}
+		}
+		if from <= 0 || to > 65535 || from > to {
+			return nil, errors.Errorf("bad port %q", item)
+		}
+		result = append(result, portRange{from, to})
+	}
+	return result, nil
+}
+
+// isServerPort checks whether port is in -p ports, which are local ports,
+// or remote ports in outgoing mode. Requests are always sent to server port.
+func isServerPort(port string) bool {
+	ranges, err := parsePorts(*localPort)
+	if err != nil {
+		return false
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return false
+	}
+	for _, r := range ranges {
+		if p >= r.from && p <= r.to {
+			return true
+		}
+	}
+	return false
+}
+
 func getDev(devName string) pcap.Interface {
 	devices, err := pcap.FindAllDevs()
 	if err != nil {
@@ -77,60 +215,358 @@ func getAllIps(dev pcap.Interface) []string {
 	return localIps
 }
 
+// selectDevs filters devices by name patterns, all devices are included if
+// include is empty.
+func selectDevs(devs []pcap.Interface, include, exclude []string) ([]pcap.Interface, error) {
+	var result []pcap.Interface
+	for _, d := range devs {
+		ok, err := matchAny(include, d.Name)
+		if err != nil {
+			return nil, err
+		}
+		if len(include) > 0 && !ok {
+			continue
+		}
+		if ok, err = matchAny(exclude, d.Name); err != nil {
+			return nil, err
+		} else if ok {
+			continue
+		}
+		result = append(result, d)
+	}
+	return result, nil
+}
+
+func matchAny(patterns []string, name string) (bool, error) {
+	for _, p := range patterns {
+		ok, err := path.Match(p, name)
+		if err != nil {
+			return false, errors.Annotate(err, "bad interface pattern "+p)
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// getHosts returns hosts to capture on dev, -host replaces its addresses,
+// -addhost extends them.
+// isFlagSet tells whether flag is given in command line
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+func getHosts(dev pcap.Interface, hosts, extraHosts []string) []string {
+	result := hosts
+	if len(result) == 0 {
+		result = getAllIps(dev)
+	}
+	return append(result[:len(result):len(result)], extraHosts...)
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		replayMain(os.Args[2:])
+		return
+	}
+	// redis-stats mode prints command rates, hot keys, largest values and
+	// errors periodically instead of commands, eg: pipe redis-stats -p 6379 -r
+	redisStats := len(os.Args) > 1 && os.Args[1] == "redis-stats"
+	if redisStats {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+		*decodeAs = "redis"
+	}
 	flag.Parse()
 
-	var wg sync.WaitGroup
-	allDevs := getAlldevs()
-	wg.Add(len(allDevs) + 1)
-
+	if _, err := parsePorts(*localPort); err != nil {
+		panic(err)
+	}
+	if *bpfExpr != "" && *bpfAndExpr != "" {
+		panic("-bpf and -bpfand can't be used together")
+	}
+	// -p isn't used in filter with -bpf, but still tells request from
+	// response, so don't fall back to 80 silently
+	if *bpfExpr != "" && !isFlagSet("p") {
+		panic("-bpf needs -p to tell server side of captured traffic")
+	}
 	_decodeAs := *decodeAs
 	if *deepDecode != "" {
 		_decodeAs = *deepDecode
 	}
-	d, err := decoder.GetDecoder(_decodeAs)
+	if _decodeAs == "help" {
+		printDecoders()
+		return
+	}
+	decoderArgs, err := decoder.ParseArgs(*decoderOpts)
+	if err != nil {
+		panic(err)
+	}
+	// check decoder and its options
+	if _, err := decoder.NewDecoder(_decodeAs, decoderArgs); err != nil {
+		panic(err)
+	}
+
+	if *pbDesc != "" {
+		if err := http.LoadProtoDescriptor(*pbDesc, *pbType); err != nil {
+			panic(err)
+		}
+	}
+
+	printer, err := decoder.NewPrinter(*output, os.Stdout)
+	if err != nil {
+		panic(err)
+	}
+	printer = newLimitPrinter(printer, *count, stop)
+
+	var differ *http.Differ
+	if *diffTarget != "" {
+		if !*traceResp || _decodeAs != "http" {
+			panic("-diff only works with -r and http decoder")
+		}
+		differ = http.NewDiffer(printer, *diffTarget, splitList(*diffHeaders), splitList(*diffFields))
+		printer = differ
+	}
+
+	var statsPrinter *redis.StatsPrinter
+	if redisStats {
+		if _decodeAs != "redis" || *statsWindow <= 0 {
+			panic("redis-stats mode needs redis decoder and positive -rstats")
+		}
+		statsPrinter = redis.NewStatsPrinter(os.Stdout, *statsWindow, *redisTop)
+		// -c and output errors stop pipe in stats mode too
+		printer = newLimitPrinter(statsPrinter, *count, stop)
+		if *pcapFile == "" {
+			// report quiet windows too in live capture
+			go tickStats(statsPrinter)
+		}
+	}
+
+	factory := NewStreamFactory(_decodeAs, *filterStr, printer)
+	factory.decoderArgs = decoderArgs
+	if *recordFile != "" {
+		recorder, err := NewRecorder(*recordFile)
+		if err != nil {
+			panic(err)
+		}
+		defer recorder.Close()
+		factory.recorder = recorder
+	}
+	pool := tcpassembly.NewStreamPool(factory)
+
+	var dumper *Dumper
+	if *dumpFile != "" {
+		dumper = NewDumper(*dumpFile, int64(*dumpSize)*1024*1024, *dumpTime)
+		defer dumper.Close()
+	}
+
+	handleSignals()
+	if *duration > 0 {
+		time.AfterFunc(*duration, stop)
+	}
+	if *pcapFile != "" {
+		readFile(*pcapFile, pool, dumper)
+	} else {
+		listenAll(pool, dumper)
+	}
+	factory.Wait()
+	if differ != nil {
+		differ.Close()
+	}
+	if statsPrinter != nil {
+		statsPrinter.Close()
+	}
+	stats.Print(os.Stderr)
+}
+
+// tickStats reports redis stats by wall clock until pipe is stopping.
+func tickStats(p *redis.StatsPrinter) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopping:
+			return
+		case now := <-ticker.C:
+			if err := p.Tick(now); err != nil {
+				log.Println("fail to write redis stats, stop:", err)
+				stop()
+				return
+			}
+		}
+	}
+}
+
+// printDecoders prints registered decoders for `-d help`
+func printDecoders() {
+	for _, info := range decoder.List() {
+		fmt.Printf("%-8s %s\n", info.Name, info.Description)
+		args := make([]string, 0, len(info.Args))
+		for name := range info.Args {
+			args = append(args, name)
+		}
+		sort.Strings(args)
+		for _, name := range args {
+			fmt.Printf("         -dopt %s=...: %s\n", name, info.Args[name])
+		}
+	}
+}
+
+// splitList splits comma separated flag value
+func splitList(s string) []string {
+	var result []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// readFile decodes packets from a pcap file, returns at EOF.
+func readFile(file string, pool *tcpassembly.StreamPool, dumper *Dumper) {
+	handle, err := pcap.OpenOffline(file)
 	if err != nil {
 		panic(err)
 	}
-	d.SetFilter(*filterStr)
+	defer handle.Close()
+	if err = handle.SetBPFFilter(fileBPFFilter()); err != nil {
+		panic(err)
+	}
+	capture(handle, pool, dumper, false)
+}
 
-	s := NewStream(d)
-	go s.To(os.Stdout)
+// fileBPFFilter filters pcap file by -host and -addhost, local addresses are
+// unknown for file, so it only filters by port without them.
+func fileBPFFilter() string {
+	fileHosts := append(splitList(*hosts), splitList(*extraHosts)...)
+	return composeBPFFilter(buildBPFFilter(*traceResp, fileHosts, *localPort, *outgoing), *bpfExpr, *bpfAndExpr)
+}
 
+func listenAll(pool *tcpassembly.StreamPool, dumper *Dumper) {
+	var wg sync.WaitGroup
+	allDevs, err := selectDevs(getAlldevs(), splitList(*interfaces), splitList(*excludeIntfs))
+	if err != nil {
+		panic(err)
+	}
+	if len(allDevs) == 0 {
+		panic("No interface to capture")
+	}
+	wg.Add(len(allDevs))
 	for _, dev := range allDevs {
 		// use one goroutine for every device
 		go func(d pcap.Interface) {
+			defer wg.Done()
 			handle, err := pcap.OpenLive(d.Name, 65536, true, pcap.BlockForever)
 			if err != nil {
 				log.Println("fail to listen:" + d.Name)
-				wg.Done()
 				return
 			}
 			defer handle.Close()
 
 			var localIps []string
-			if localIps = getAllIps(d); len(localIps) == 0 {
-				wg.Done()
+			// raw filter doesn't need hosts
+			if localIps = getHosts(d, splitList(*hosts), splitList(*extraHosts)); len(localIps) == 0 && *bpfExpr == "" {
 				return
 			}
 
-			if err = handle.SetBPFFilter(buildBPFFilter(*traceResp, localIps, *localPort)); err != nil {
-				log.Println("Failed to set BPF for:" + d.Name)
-				wg.Done()
+			filter := composeBPFFilter(buildBPFFilter(*traceResp, localIps, *localPort, *outgoing), *bpfExpr, *bpfAndExpr)
+			if err = handle.SetBPFFilter(filter); err != nil {
+				log.Println("Failed to set BPF for:"+d.Name, filter, err)
 				return
 			}
-
-			packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
-			for packet := range packetSource.Packets() {
-				if app := packet.ApplicationLayer(); app != nil {
-					_, err := s.Write(app.Payload()) // Write data to stream
-					if err != nil {
-						panic(err) // TODO handle error
-					}
-				}
-			}
-			wg.Done()
+			capture(handle, pool, dumper, true)
 		}(dev)
 	}
 	wg.Wait()
 }
+
+// capture feeds packets from handle to its own assembler until handle is
+// exhausted or pipe is stopping. Assembler is not goroutine safe, but pool
+// can be shared. If dumper is not nil, raw packets are saved too. live is
+// true for capture from interface.
+func capture(handle *pcap.Handle, pool *tcpassembly.StreamPool, dumper *Dumper, live bool) {
+	assembler := tcpassembly.NewAssembler(pool)
+	packets := gopacket.NewPacketSource(handle, handle.LinkType()).Packets()
+	f := &flusher{assembler: assembler}
+	// live capture flushes by wall clock too, so streams are flushed
+	// without new packets
+	var tick <-chan time.Time
+	if live {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		var packet gopacket.Packet
+		var ok bool
+		select {
+		case <-stopping:
+		case now := <-tick:
+			f.flush(now)
+			continue
+		case packet, ok = <-packets:
+		}
+		if !ok {
+			break
+		}
+		stats.AddPacket()
+		if dumper != nil {
+			if err := dumper.WritePacket(handle.LinkType(), packet.Metadata().CaptureInfo, packet.Data()); err != nil {
+				log.Println("Failed to dump packet:", err)
+			}
+		}
+		assemble(assembler, packet)
+		// use capture time instead of wall clock, works for pcap files too
+		f.flush(packet.Metadata().Timestamp)
+	}
+	assembler.FlushAll()
+	// not supported by offline handle
+	if s, err := handle.Stats(); err == nil {
+		stats.AddDropped(s.PacketsDropped, s.PacketsIfDropped)
+	}
+}
+
+const (
+	// data waiting for lost segments is given up after it, so is data of
+	// connections started before capture, which never get SYN
+	flushAfter = 3 * time.Second
+	// idle connections are closed after it
+	closeAfter = 2 * time.Minute
+)
+
+// flusher flushes streams of assembler every second, and closes idle
+// connections every minute.
+type flusher struct {
+	assembler *tcpassembly.Assembler
+	lastFlush time.Time
+	lastClose time.Time
+}
+
+func (f *flusher) flush(now time.Time) {
+	if now.Sub(f.lastFlush) >= time.Second {
+		f.assembler.FlushWithOptions(tcpassembly.FlushOptions{T: now.Add(-flushAfter)})
+		f.lastFlush = now
+	}
+	if now.Sub(f.lastClose) >= time.Minute {
+		f.assembler.FlushOlderThan(now.Add(-closeAfter))
+		f.lastClose = now
+	}
+}
+
+func assemble(assembler *tcpassembly.Assembler, packet gopacket.Packet) {
+	if packet.NetworkLayer() == nil || packet.TransportLayer() == nil {
+		return
+	}
+	tcp, ok := packet.TransportLayer().(*layers.TCP)
+	if !ok {
+		return
+	}
+	assembler.AssembleWithTimestamp(packet.NetworkLayer().NetworkFlow(), tcp, packet.Metadata().Timestamp)
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"net"
+	"strings"
 	"testing"
+
+	"github.com/google/gopacket/pcap"
 )
 
 func assertEqual(t *testing.T, result interface{}, expected interface{}) {
@@ -13,13 +17,87 @@ func assertEqual(t *testing.T, result interface{}, expected interface{}) {
 func TestBuildBPFFilter(t *testing.T) {
 	// one host
 	result := buildBPFFilter(false,
-		[]string{"127.0.0.1"}, "80")
+		[]string{"127.0.0.1"}, "80", false)
 	assertEqual(t, result, "tcp dst port 80 and ( dst host 127.0.0.1)")
 	// multi host
 	result = buildBPFFilter(false,
-		[]string{"127.0.0.1", "10.0.0.10"}, "5010")
+		[]string{"127.0.0.1", "10.0.0.10"}, "5010", false)
 	assertEqual(t, result, "tcp dst port 5010 and ( dst host 127.0.0.1 or dst host 10.0.0.10)")
 	// track response
-	result = buildBPFFilter(true, []string{"127.0.0.1"}, "80")
+	result = buildBPFFilter(true, []string{"127.0.0.1"}, "80", false)
 	assertEqual(t, result, "tcp port 80 and ( host 127.0.0.1)")
+	// no host, eg: read from pcap file
+	result = buildBPFFilter(false, nil, "80", false)
+	assertEqual(t, result, "tcp dst port 80")
+	// multi ports
+	result = buildBPFFilter(false, []string{"127.0.0.1"}, "6379,7000-7005", false)
+	assertEqual(t, result, "tcp (dst port 6379 or dst portrange 7000-7005) and ( dst host 127.0.0.1)")
+	result = buildBPFFilter(true, nil, "6379,6380", false)
+	assertEqual(t, result, "tcp (port 6379 or port 6380)")
+}
+
+func TestComposeBPFFilter(t *testing.T) {
+	assertEqual(t, composeBPFFilter("tcp port 80", "", ""), "tcp port 80")
+	assertEqual(t, composeBPFFilter("tcp port 80", "udp", ""), "udp")
+	assertEqual(t, composeBPFFilter("tcp port 80", "", "not host 10.0.0.5"), "(tcp port 80) and (not host 10.0.0.5)")
+}
+
+func TestFileBPFFilter(t *testing.T) {
+	assertEqual(t, fileBPFFilter(), "tcp dst port 80")
+	*hosts, *extraHosts = "10.0.0.1", "10.0.0.2"
+	defer func() { *hosts, *extraHosts = "", "" }()
+	assertEqual(t, fileBPFFilter(), "tcp dst port 80 and ( dst host 10.0.0.1 or dst host 10.0.0.2)")
+}
+
+func TestParsePorts(t *testing.T) {
+	ranges, err := parsePorts("6379, 7000-7005")
+	assertEqual(t, err, nil)
+	assertEqual(t, len(ranges), 2)
+	assertEqual(t, ranges[1], portRange{7000, 7005})
+	for _, s := range []string{"", "abc", "7005-7000", "70000", "1-x"} {
+		if _, err := parsePorts(s); err == nil {
+			t.Error("expect error for", s)
+		}
+	}
+	*localPort = "6379,7000-7005"
+	defer func() { *localPort = "80" }()
+	assertEqual(t, isServerPort("6379"), true)
+	assertEqual(t, isServerPort("7003"), true)
+	assertEqual(t, isServerPort("6380"), false)
+}
+
+func TestSelectDevs(t *testing.T) {
+	devs := []pcap.Interface{{Name: "eth0"}, {Name: "lo"}, {Name: "docker0"}, {Name: "veth12"}, {Name: "veth34"}}
+	names := func(devs []pcap.Interface) string {
+		var result []string
+		for _, d := range devs {
+			result = append(result, d.Name)
+		}
+		return strings.Join(result, ",")
+	}
+	result, _ := selectDevs(devs, nil, nil)
+	assertEqual(t, names(result), "eth0,lo,docker0,veth12,veth34")
+	result, _ = selectDevs(devs, []string{"eth0", "lo"}, nil)
+	assertEqual(t, names(result), "eth0,lo")
+	result, _ = selectDevs(devs, nil, []string{"veth*", "docker*"})
+	assertEqual(t, names(result), "eth0,lo")
+	result, _ = selectDevs(devs, []string{"veth*"}, []string{"veth3?"})
+	assertEqual(t, names(result), "veth12")
+	if _, err := selectDevs(devs, []string{"[eth"}, nil); err == nil {
+		t.Error("expect error for bad pattern")
+	}
+}
+
+func TestGetHosts(t *testing.T) {
+	dev := pcap.Interface{Name: "eth0", Addresses: []pcap.InterfaceAddress{{IP: net.ParseIP("10.0.0.1")}}}
+	assertEqual(t, strings.Join(getHosts(dev, nil, nil), ","), "10.0.0.1")
+	assertEqual(t, strings.Join(getHosts(dev, nil, []string{"172.17.0.2"}), ","), "10.0.0.1,172.17.0.2")
+	assertEqual(t, strings.Join(getHosts(dev, []string{"10.0.0.2"}, []string{"172.17.0.2"}), ","), "10.0.0.2,172.17.0.2")
+}
+
+func TestBuildOutgoingBPFFilter(t *testing.T) {
+	result := buildBPFFilter(false, []string{"10.0.0.1"}, "6379", true)
+	assertEqual(t, result, "tcp dst port 6379 and ( src host 10.0.0.1)")
+	result = buildBPFFilter(true, []string{"10.0.0.1"}, "6379", true)
+	assertEqual(t, result, "tcp port 6379 and ( host 10.0.0.1)")
 }
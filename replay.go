@@ -0,0 +1,148 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Replayer plays recorded client streams back against target, one
+// connection for every recorded connection, responses are discarded.
+// Data is sent at recorded time divided by speed, speed 0 means as fast as
+// possible.
+type Replayer struct {
+	target string
+	speed  float64
+	start  time.Time
+	wg     sync.WaitGroup
+	conns  map[uint32]chan *Chunk
+
+	bytes  int64
+	failed int64
+}
+
+func (r *Replayer) Run(s *SessionReader) error {
+	r.start = time.Now()
+	for {
+		c, err := s.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			r.closeAll()
+			return err
+		}
+		ch, ok := r.conns[c.Conn]
+		if !ok {
+			if len(c.Data) == 0 {
+				continue
+			}
+			ch = make(chan *Chunk, 1024)
+			r.conns[c.Conn] = ch
+			r.wg.Add(1)
+			go r.replay(c.Conn, ch)
+		}
+		ch <- c
+		if len(c.Data) == 0 {
+			close(ch)
+			delete(r.conns, c.Conn)
+		}
+	}
+	r.closeAll()
+	return nil
+}
+
+func (r *Replayer) closeAll() {
+	for id, ch := range r.conns {
+		close(ch)
+		delete(r.conns, id)
+	}
+	r.wg.Wait()
+}
+
+// wait until chunk's time to send
+func (r *Replayer) wait(c *Chunk) {
+	if r.speed <= 0 {
+		return
+	}
+	at := r.start.Add(time.Duration(float64(c.Offset) / r.speed))
+	if d := time.Until(at); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+func (r *Replayer) replay(id uint32, chunks chan *Chunk) {
+	defer r.wg.Done()
+	// dial at first data's time
+	first, ok := <-chunks
+	if !ok {
+		return
+	}
+	r.wait(first)
+	conn, err := net.DialTimeout("tcp", r.target, 5*time.Second)
+	if err != nil {
+		log.Println("conn", id, "fail to connect replay target:", err)
+		atomic.AddInt64(&r.failed, 1)
+		for range chunks {
+		}
+		return
+	}
+	defer conn.Close()
+	go io.Copy(ioutil.Discard, conn)
+	for c := first; c != nil; c = <-chunks {
+		if len(c.Data) == 0 {
+			break
+		}
+		r.wait(c)
+		if _, err := conn.Write(c.Data); err != nil {
+			log.Println("conn", id, "fail to write replay target:", err)
+			atomic.AddInt64(&r.failed, 1)
+			break
+		}
+		atomic.AddInt64(&r.bytes, int64(len(c.Data)))
+	}
+	for range chunks {
+	}
+}
+
+func NewReplayer(target string, speed float64) *Replayer {
+	return &Replayer{target: target, speed: speed, conns: make(map[uint32]chan *Chunk)}
+}
+
+// replayMain implements `pipe replay`
+func replayMain(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	target := fs.String("target", "", "host:port to send recorded streams to")
+	speed := fs.Float64("speed", 1, "replay speed, 2 means twice as fast as recorded, 0 means as fast as possible")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: pipe replay -target host:port [-speed N] session_file")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *target == "" || fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	s, err := NewSessionReader(f)
+	if err != nil {
+		panic(err)
+	}
+	r := NewReplayer(*target, *speed)
+	start := time.Now()
+	if err := r.Run(s); err != nil {
+		log.Println(err)
+	}
+	log.Printf("replayed %d bytes in %s, %d connections failed\n", r.bytes, time.Since(start), r.failed)
+}
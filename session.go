@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+const sessionMagic = "PIPESESSION1"
+
+// Chunk is a piece of client stream in session file, empty Data means
+// connection is closed.
+type Chunk struct {
+	Conn   uint32
+	Offset time.Duration // since first recorded data
+	Data   []byte
+}
+
+// Recorder saves reassembled client streams with capture time into session
+// file, which can be played back by `pipe replay`.
+// file format: magic, then chunks of
+// conn id(uint32) | offset ns(int64) | length(uint32) | data, big endian
+type Recorder struct {
+	mu     sync.Mutex
+	file   *os.File
+	w      *bufio.Writer
+	start  time.Time
+	nextID uint32
+}
+
+// RecordConn records one client stream.
+type RecordConn struct {
+	r       *Recorder
+	id      uint32
+	written bool
+}
+
+func (c *RecordConn) Write(ts time.Time, data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	c.written = true
+	return c.r.write(c.id, ts, data)
+}
+
+// Close records end of stream, stream without data is ignored.
+func (c *RecordConn) Close(ts time.Time) error {
+	if !c.written {
+		return nil
+	}
+	return c.r.write(c.id, ts, nil)
+}
+
+func (r *Recorder) NewConn() *RecordConn {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextID++
+	return &RecordConn{r: r, id: r.nextID}
+}
+
+func (r *Recorder) write(id uint32, ts time.Time, data []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.start.IsZero() {
+		r.start = ts
+	}
+	var header [16]byte
+	binary.BigEndian.PutUint32(header[0:], id)
+	binary.BigEndian.PutUint64(header[4:], uint64(ts.Sub(r.start)))
+	binary.BigEndian.PutUint32(header[12:], uint32(len(data)))
+	if _, err := r.w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := r.w.Write(data)
+	return err
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.w.Flush(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+func NewRecorder(path string) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{file: f, w: bufio.NewWriter(f)}
+	if _, err := r.w.WriteString(sessionMagic); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// SessionReader reads chunks from session file in recorded order.
+type SessionReader struct {
+	r *bufio.Reader
+}
+
+// Next returns io.EOF at end of file.
+func (s *SessionReader) Next() (*Chunk, error) {
+	var header [16]byte
+	if _, err := io.ReadFull(s.r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errors.New("truncated session file")
+		}
+		return nil, err
+	}
+	c := &Chunk{
+		Conn:   binary.BigEndian.Uint32(header[0:]),
+		Offset: time.Duration(binary.BigEndian.Uint64(header[4:])),
+		Data:   make([]byte, binary.BigEndian.Uint32(header[12:])),
+	}
+	if _, err := io.ReadFull(s.r, c.Data); err != nil {
+		return nil, errors.New("truncated session file")
+	}
+	return c, nil
+}
+
+func NewSessionReader(r io.Reader) (*SessionReader, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(sessionMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != sessionMagic {
+		return nil, errors.New("not a pipe session file")
+	}
+	return &SessionReader{r: br}, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/monsterxx03/pipe/decoder"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/tcpassembly"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pipe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "session")
+
+	*localPort = "6379"
+	defer func() { *localPort = "80" }()
+	recorder, err := NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	printer, _ := decoder.NewPrinter("text", ioutil.Discard)
+	factory := NewStreamFactory("redis", "", printer)
+	factory.recorder = recorder
+	assembler := tcpassembly.NewAssembler(tcpassembly.NewStreamPool(factory))
+	start := time.Now()
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+	packets := []gopacket.Packet{
+		tcpPacketAt(t, at(0), 5000, 6379, 100, true, false, ""),
+		tcpPacketAt(t, at(0), 5000, 6379, 101, false, false, "*1\r\n$4\r\nping\r\n"),
+		tcpPacketAt(t, at(10), 5001, 6379, 200, true, false, ""),
+		tcpPacketAt(t, at(10), 5001, 6379, 201, false, false, "*2\r\n$3\r\nget\r\n$1\r\na\r\n"),
+		tcpPacketAt(t, at(30), 5000, 6379, 115, false, false, "*1\r\n$4\r\nping\r\n"),
+		tcpPacketAt(t, at(40), 5000, 6379, 129, false, true, ""),
+		tcpPacketAt(t, at(40), 5001, 6379, 221, false, true, ""),
+	}
+	for _, packet := range packets {
+		assemble(assembler, packet)
+	}
+	assembler.FlushAll()
+	factory.Wait()
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	s, err := NewSessionReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offsets := make(map[string][]time.Duration)
+	for {
+		c, err := s.Next()
+		if err != nil {
+			break
+		}
+		key := string(c.Data)
+		offsets[key] = append(offsets[key], c.Offset)
+	}
+	assertEqual(t, fmt.Sprint(offsets["*1\r\n$4\r\nping\r\n"]), "[0s 30ms]")
+	assertEqual(t, fmt.Sprint(offsets["*2\r\n$3\r\nget\r\n$1\r\na\r\n"]), "[10ms]")
+	assertEqual(t, len(offsets[""]), 2)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	received := make(chan string)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				data, _ := ioutil.ReadAll(c)
+				received <- string(data)
+			}(conn)
+		}
+	}()
+	f.Seek(0, 0)
+	s, _ = NewSessionReader(f)
+	replayStart := time.Now()
+	if err := NewReplayer(l.Addr().String(), 1).Run(s); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(replayStart); elapsed < 30*time.Millisecond {
+		t.Error("replay is too fast:", elapsed)
+	}
+	var result []string
+	for i := 0; i < 2; i++ {
+		select {
+		case data := <-received:
+			result = append(result, data)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting replay")
+		}
+	}
+	sort.Strings(result)
+	assertEqual(t, result[0], "*1\r\n$4\r\nping\r\n*1\r\n$4\r\nping\r\n")
+	assertEqual(t, result[1], "*2\r\n$3\r\nget\r\n$1\r\na\r\n")
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/monsterxx03/pipe/decoder"
+)
+
+// max number of flows printed in summary
+const summaryFlows = 20
+
+// Stats collects counters printed in summary when pipe exits.
+type Stats struct {
+	packets       uint64
+	dropped       uint64 // by kernel
+	ifDropped     uint64 // by interface
+	parseErrors   uint64
+	mu            sync.Mutex
+	flowBytes     map[string]uint64
+	flowErrors    map[string]uint64
+	protoMessages map[string]uint64
+}
+
+func (s *Stats) AddPacket() {
+	atomic.AddUint64(&s.packets, 1)
+}
+
+func (s *Stats) AddDropped(dropped, ifDropped int) {
+	atomic.AddUint64(&s.dropped, uint64(dropped))
+	atomic.AddUint64(&s.ifDropped, uint64(ifDropped))
+}
+
+func (s *Stats) AddParseError(flow string) {
+	atomic.AddUint64(&s.parseErrors, 1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flowErrors[flow]++
+}
+
+func (s *Stats) AddFlowBytes(flow string, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flowBytes[flow] += uint64(n)
+}
+
+func (s *Stats) AddMessage(protocol string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.protoMessages[protocol]++
+}
+
+// Print writes summary, flows are sorted by bytes.
+func (s *Stats) Print(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(w, "packets: %d captured, %d dropped by kernel, %d dropped by interface\n",
+		atomic.LoadUint64(&s.packets), atomic.LoadUint64(&s.dropped), atomic.LoadUint64(&s.ifDropped))
+	protos := make([]string, 0, len(s.protoMessages))
+	for p := range s.protoMessages {
+		protos = append(protos, p)
+	}
+	sort.Strings(protos)
+	fmt.Fprint(w, "messages:")
+	for _, p := range protos {
+		fmt.Fprintf(w, " %s %d", p, s.protoMessages[p])
+	}
+	fmt.Fprintf(w, "\nparse errors: %d\n", atomic.LoadUint64(&s.parseErrors))
+	flows := make([]string, 0, len(s.flowBytes))
+	for f := range s.flowBytes {
+		flows = append(flows, f)
+	}
+	sort.Slice(flows, func(i, j int) bool {
+		if s.flowBytes[flows[i]] != s.flowBytes[flows[j]] {
+			return s.flowBytes[flows[i]] > s.flowBytes[flows[j]]
+		}
+		return flows[i] < flows[j]
+	})
+	fmt.Fprintf(w, "bytes per flow: %d flows\n", len(flows))
+	for i, f := range flows {
+		if i == summaryFlows {
+			fmt.Fprintf(w, "  ... %d more\n", len(flows)-i)
+			break
+		}
+		fmt.Fprintf(w, "  %s %d", f, s.flowBytes[f])
+		if n := s.flowErrors[f]; n > 0 {
+			fmt.Fprintf(w, " (%d parse errors)", n)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+func NewStats() *Stats {
+	return &Stats{
+		flowBytes:     make(map[string]uint64),
+		flowErrors:    make(map[string]uint64),
+		protoMessages: make(map[string]uint64),
+	}
+}
+
+// limitPrinter stops pipe after max msgs or transactions are printed, 0
+// means no limit, pipe is stopped on output error too, eg: broken pipe.
+type limitPrinter struct {
+	printer decoder.Printer
+	max     int64
+	count   int64
+	stop    func()
+}
+
+func (p *limitPrinter) Print(msg *decoder.Message) error {
+	return p.print(func() error { return p.printer.Print(msg) })
+}
+
+func (p *limitPrinter) PrintTransaction(t *decoder.Transaction) error {
+	return p.print(func() error { return p.printer.PrintTransaction(t) })
+}
+
+func (p *limitPrinter) print(f func() error) error {
+	n := atomic.AddInt64(&p.count, 1)
+	if p.max > 0 && n > p.max {
+		return nil
+	}
+	if err := f(); err != nil {
+		log.Println("fail to write output, stop:", err)
+		p.stop()
+		return err
+	}
+	if n == p.max {
+		p.stop()
+	}
+	return nil
+}
+
+func newLimitPrinter(printer decoder.Printer, max int64, stop func()) *limitPrinter {
+	return &limitPrinter{printer: printer, max: max, stop: stop}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/monsterxx03/pipe/decoder"
+)
+
+func TestStatsPrint(t *testing.T) {
+	s := NewStats()
+	s.AddPacket()
+	s.AddPacket()
+	s.AddDropped(3, 1)
+	s.AddParseError("10.0.0.1:5000 -> 10.0.0.2:6379")
+	s.AddFlowBytes("10.0.0.1:5000 -> 10.0.0.2:6379", 10)
+	s.AddFlowBytes("10.0.0.1:5001 -> 10.0.0.2:6379", 20)
+	s.AddMessage("redis")
+	s.AddMessage("redis")
+	s.AddMessage("http")
+	var buf bytes.Buffer
+	s.Print(&buf)
+	assertEqual(t, buf.String(), "packets: 2 captured, 3 dropped by kernel, 1 dropped by interface\n"+
+		"messages: http 1 redis 2\n"+
+		"parse errors: 1\n"+
+		"bytes per flow: 2 flows\n"+
+		"  10.0.0.1:5001 -> 10.0.0.2:6379 20\n"+
+		"  10.0.0.1:5000 -> 10.0.0.2:6379 10 (1 parse errors)\n")
+}
+
+type errPrinter struct{}
+
+func (p errPrinter) Print(msg *decoder.Message) error              { return errors.New("broken pipe") }
+func (p errPrinter) PrintTransaction(t *decoder.Transaction) error { return nil }
+
+func TestLimitPrinter(t *testing.T) {
+	var buf bytes.Buffer
+	printer, _ := decoder.NewPrinter("text", &buf)
+	stopped := 0
+	p := newLimitPrinter(printer, 2, func() { stopped++ })
+	for _, text := range []string{"a\n", "b\n", "c\n"} {
+		p.Print(&decoder.Message{Text: text})
+	}
+	assertEqual(t, buf.String(), "a\nb\n")
+	assertEqual(t, stopped, 1)
+
+	stopped = 0
+	p = newLimitPrinter(errPrinter{}, 0, func() { stopped++ })
+	if err := p.Print(&decoder.Message{}); err == nil {
+		t.Error("expect output error")
+	}
+	assertEqual(t, stopped, 1)
+}
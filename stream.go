@@ -1,36 +1,260 @@
 package main
 
 import (
-	"github.com/monsterxx03/pipe/decoder"
 	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/monsterxx03/pipe/decoder"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/tcpassembly"
+	"github.com/google/gopacket/tcpassembly/tcpreader"
 )
 
+// Stream is one direction of a tcp connection, reassembled in order and
+// decoded by its own decoder instance. It implements tcpassembly.Stream,
+// and io.Reader for decoder.
 type Stream struct {
-	pr      *io.PipeReader
-	pw      *io.PipeWriter
+	net     gopacket.Flow
+	tcp     gopacket.Flow
 	decoder decoder.Decoder
+	opts    *decoder.Options
+	printer decoder.Printer
+	pairing *decoder.Pairing
+	mirror  *Mirror
+	record  *RecordConn
+	flow    string
+
+	reassembled chan []tcpassembly.Reassembly
+	done        chan bool
+	current     []tcpassembly.Reassembly
+	first       bool
+	closed      bool
+	seen        time.Time
+}
+
+// Reassembled implements tcpassembly.Stream, blocks until data is consumed.
+func (s *Stream) Reassembled(reassembly []tcpassembly.Reassembly) {
+	s.reassembled <- reassembly
+	<-s.done
 }
 
-func (s *Stream) Write(data []byte) (int, error) {
-	return s.pw.Write(data)
+// ReassemblyComplete implements tcpassembly.Stream.
+func (s *Stream) ReassemblyComplete() {
+	close(s.reassembled)
+	close(s.done)
 }
 
 func (s *Stream) Read(data []byte) (int, error) {
-	return s.pr.Read(data)
+	var ok bool
+	s.stripEmpty()
+	for !s.closed && len(s.current) == 0 {
+		if s.first {
+			s.first = false
+		} else {
+			s.done <- true
+		}
+		if s.current, ok = <-s.reassembled; ok {
+			s.checkGap()
+			s.stripEmpty()
+		} else {
+			s.closed = true
+		}
+	}
+	if len(s.current) == 0 {
+		return 0, io.EOF
+	}
+	current := &s.current[0]
+	s.seen = current.Seen
+	n := copy(data, current.Bytes)
+	current.Bytes = current.Bytes[n:]
+	stats.AddFlowBytes(s.flow, n)
+	if s.mirror != nil {
+		s.mirror.Write(data[:n])
+	}
+	if s.record != nil {
+		if err := s.record.Write(s.seen, data[:n]); err != nil {
+			log.Println("fail to record session:", err)
+		}
+	}
+	return n, nil
 }
 
-func (s *Stream) To(w io.Writer) {
-	opts := new(decoder.Options)
-	if *deepDecode != "" {
-		opts.DeepDecode = true
+// checkGap stops mirroring if bytes are missed before the data, or capture
+// started in the middle of the stream.
+func (s *Stream) checkGap() {
+	if s.mirror == nil {
+		return
 	}
-	if err := s.decoder.Decode(s.pr, w, opts); err != nil {
-		panic(err)
+	for _, r := range s.current {
+		if r.Skip != 0 {
+			s.mirror.Gap()
+			return
+		}
+	}
+}
+
+func (s *Stream) stripEmpty() {
+	for len(s.current) > 0 && len(s.current[0].Bytes) == 0 {
+		s.current = s.current[1:]
+	}
+}
+
+// WriteMessage implements decoder.MessageWriter, fills connection info and
+// capture time of the data last read into msg.
+func (s *Stream) WriteMessage(msg *decoder.Message) error {
+	msg.Src = net.JoinHostPort(s.net.Src().String(), s.tcp.Src().String())
+	msg.Dst = net.JoinHostPort(s.net.Dst().String(), s.tcp.Dst().String())
+	msg.Timestamp = s.seen
+	stats.AddMessage(msg.Protocol)
+	if msg.Direction == decoder.UnknownDirection {
+		if s.opts.IsRequest {
+			msg.Direction = decoder.Request
+		} else {
+			msg.Direction = decoder.Response
+		}
+	}
+	if s.pairing != nil {
+		s.pairing.Add(msg)
+		return nil
+	}
+	if msg.Skip {
+		return nil
+	}
+	return s.printer.Print(msg)
+}
+
+// ReportError implements decoder.ErrorReporter, decoder goes on after it.
+func (s *Stream) ReportError(err error) {
+	log.Println(s.flow, err)
+	stats.AddParseError(s.flow)
+}
+
+func (s *Stream) run() {
+	if err := s.decoder.Decode(s, s, s.opts); err != nil && err != io.EOF {
+		s.ReportError(err)
+	}
+	// decoder may stop before stream end, drain the rest to keep assembler going
+	tcpreader.DiscardBytesToEOF(s)
+	if s.mirror != nil {
+		s.mirror.Close()
+	}
+	if s.record != nil {
+		s.record.Close(s.seen)
 	}
 }
 
-func NewStream(decoder decoder.Decoder) *Stream {
-	pr, pw := io.Pipe()
-	s := &Stream{pr, pw, decoder}
+func NewStream(net, tcp gopacket.Flow, decoder decoder.Decoder, opts *decoder.Options, printer decoder.Printer, pairing *decoder.Pairing) *Stream {
+	return &Stream{
+		net:         net,
+		tcp:         tcp,
+		decoder:     decoder,
+		opts:        opts,
+		printer:     printer,
+		pairing:     pairing,
+		flow:        net.Src().String() + ":" + tcp.Src().String() + " -> " + net.Dst().String() + ":" + tcp.Dst().String(),
+		reassembled: make(chan []tcpassembly.Reassembly),
+		done:        make(chan bool),
+		first:       true,
+	}
+}
+
+type connKey struct {
+	net gopacket.Flow
+	tcp gopacket.Flow
+}
+
+// conn holds state shared by both directions of a connection.
+type conn struct {
+	pairing *decoder.Pairing
+	streams int
+}
+
+// StreamFactory creates a Stream with a fresh decoder for every new tcp flow
+// seen by the assembler.
+type StreamFactory struct {
+	decodeAs    string
+	decoderArgs map[string]string // decoder specific options
+	filter      string
+	printer     decoder.Printer
+	recorder    *Recorder // record client streams if not nil
+	wg          sync.WaitGroup
+
+	mu    sync.Mutex
+	conns map[connKey]*conn
+}
+
+func (f *StreamFactory) New(net, tcp gopacket.Flow) tcpassembly.Stream {
+	d, err := decoder.NewDecoder(f.decodeAs, f.decoderArgs)
+	if err != nil {
+		panic(err)
+	}
+	d.SetFilter(f.filter)
+	opts := &decoder.Options{
+		DeepDecode: *deepDecode != "",
+		IsRequest:  isServerPort(tcp.Dst().String()),
+	}
+	var c *conn
+	var pairing *decoder.Pairing
+	if *traceResp {
+		c = f.getConn(net, tcp)
+		pairing = c.pairing
+	}
+	s := NewStream(net, tcp, d, opts, f.printer, pairing)
+	if *mirrorTarget != "" && opts.IsRequest {
+		// one mirror connection for every captured client connection
+		s.mirror = NewMirror(*mirrorTarget)
+	}
+	if f.recorder != nil && opts.IsRequest {
+		s.record = f.recorder.NewConn()
+	}
+	f.wg.Add(1)
+	go func() {
+		s.run()
+		if c != nil {
+			f.releaseConn(net, tcp, c)
+		}
+		f.wg.Done()
+	}()
 	return s
 }
+
+// getConn returns conn shared with reverse direction, creates it if not
+// exists.
+func (f *StreamFactory) getConn(net, tcp gopacket.Flow) *conn {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.conns[connKey{net.Reverse(), tcp.Reverse()}]
+	if !ok {
+		c = &conn{pairing: decoder.NewPairing(f.printer)}
+	}
+	c.streams++
+	f.conns[connKey{net, tcp}] = c
+	return c
+}
+
+func (f *StreamFactory) releaseConn(net, tcp gopacket.Flow, c *conn) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.conns, connKey{net, tcp})
+	if c.streams--; c.streams == 0 {
+		c.pairing.Close()
+	}
+}
+
+// Wait blocks until all created streams are fully decoded.
+func (f *StreamFactory) Wait() {
+	f.wg.Wait()
+}
+
+func NewStreamFactory(decodeAs, filter string, printer decoder.Printer) *StreamFactory {
+	return &StreamFactory{
+		decodeAs: decodeAs,
+		filter:   filter,
+		printer:  printer,
+		conns:    make(map[connKey]*conn),
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/monsterxx03/pipe/decoder"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/tcpassembly"
+)
+
+var (
+	clientIP = net.IP{10, 0, 0, 1}
+	serverIP = net.IP{10, 0, 0, 2}
+)
+
+func tcpPacket(t *testing.T, srcPort, dstPort layers.TCPPort, seq uint32, syn, fin bool, payload string) gopacket.Packet {
+	return tcpPacketAt(t, time.Now(), srcPort, dstPort, seq, syn, fin, payload)
+}
+
+// tcpPacketAt builds packet captured at ts, packet from port 6379 is treated
+// as server reply.
+func tcpPacketAt(t *testing.T, ts time.Time, srcPort, dstPort layers.TCPPort, seq uint32, syn, fin bool, payload string) gopacket.Packet {
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP,
+		SrcIP: clientIP, DstIP: serverIP}
+	if srcPort == 6379 {
+		ip.SrcIP, ip.DstIP = serverIP, clientIP
+	}
+	tcp := &layers.TCP{SrcPort: srcPort, DstPort: dstPort, Seq: seq, SYN: syn, FIN: fin, Window: 1024}
+	tcp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ip, tcp, gopacket.Payload(payload)); err != nil {
+		t.Fatal(err)
+	}
+	packet := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeIPv4, gopacket.Default)
+	packet.Metadata().Timestamp = ts
+	return packet
+}
+
+func TestStreamReassembly(t *testing.T) {
+	*localPort = "6379"
+	defer func() { *localPort = "80" }()
+
+	var out bytes.Buffer
+	printer, _ := decoder.NewPrinter("text", &out)
+	factory := NewStreamFactory("redis", "", printer)
+	assembler := tcpassembly.NewAssembler(tcpassembly.NewStreamPool(factory))
+	// two connections, segments out of order and retransmitted
+	packets := []gopacket.Packet{
+		tcpPacket(t, 5000, 6379, 100, true, false, ""),
+		tcpPacket(t, 5001, 6379, 200, true, false, ""),
+		tcpPacket(t, 5000, 6379, 114, false, false, "$1\r\na\r\n"),
+		tcpPacket(t, 5001, 6379, 201, false, false, "*2\r\n$3\r\n"),
+		tcpPacket(t, 5000, 6379, 101, false, false, "*2\r\n$3\r\nget\r\n"),
+		tcpPacket(t, 5000, 6379, 101, false, false, "*2\r\n$3\r\nget\r\n"),
+		tcpPacket(t, 5001, 6379, 209, false, false, "del\r\n$1\r\nb\r\n"),
+		tcpPacket(t, 5000, 6379, 121, false, true, ""),
+		tcpPacket(t, 5001, 6379, 221, false, true, ""),
+	}
+	for _, packet := range packets {
+		assemble(assembler, packet)
+	}
+	assembler.FlushAll()
+	factory.Wait()
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	sort.Strings(lines)
+	assertEqual(t, len(lines), 2)
+	assertEqual(t, lines[0], "del b")
+	assertEqual(t, lines[1], "get a")
+}
+
+func TestStreamPairing(t *testing.T) {
+	*traceResp, *localPort = true, "6379"
+	defer func() { *traceResp, *localPort = false, "80" }()
+
+	var out bytes.Buffer
+	printer, _ := decoder.NewPrinter("text", &out)
+	factory := NewStreamFactory("redis", "", printer)
+	assembler := tcpassembly.NewAssembler(tcpassembly.NewStreamPool(factory))
+	start := time.Now()
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+
+	// pipelined requests, the last one never get reply
+	packets := []gopacket.Packet{
+		tcpPacketAt(t, at(0), 5000, 6379, 100, true, false, ""),
+		tcpPacketAt(t, at(0), 6379, 5000, 900, true, false, ""),
+		tcpPacketAt(t, at(1), 5000, 6379, 101, false, false, "*2\r\n$3\r\nget\r\n$1\r\na\r\n"),
+		tcpPacketAt(t, at(2), 5000, 6379, 121, false, false, "*2\r\n$3\r\nget\r\n$1\r\nb\r\n"),
+		tcpPacketAt(t, at(5), 6379, 5000, 901, false, false, "$1\r\n1\r\n"),
+		tcpPacketAt(t, at(12), 6379, 5000, 908, false, false, "$-1\r\n"),
+		tcpPacketAt(t, at(13), 5000, 6379, 141, false, false, "*1\r\n$4\r\nping\r\n"),
+		tcpPacketAt(t, at(14), 5000, 6379, 155, false, true, ""),
+		tcpPacketAt(t, at(14), 6379, 5000, 913, false, true, ""),
+	}
+	for _, packet := range packets {
+		assemble(assembler, packet)
+	}
+	assembler.FlushAll()
+	factory.Wait()
+
+	assertEqual(t, out.String(), "get a\n\"1\"\n(4ms)\nget b\n(nil)\n(10ms)\nping\n(no response)\n")
+}
+
+// syncBuffer is written by stream goroutines while test reads it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// waitFor waits until out has n lines
+func waitFor(out *syncBuffer, n int) []string {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		if len(lines) >= n && lines[0] != "" || time.Now().After(deadline) {
+			return lines
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStreamWithoutSYN(t *testing.T) {
+	*localPort = "6379"
+	defer func() { *localPort = "80" }()
+
+	var out syncBuffer
+	printer, _ := decoder.NewPrinter("text", &out)
+	factory := NewStreamFactory("redis", "", printer)
+	assembler := tcpassembly.NewAssembler(tcpassembly.NewStreamPool(factory))
+	f := &flusher{assembler: assembler}
+	// connection opened before capture, a ping every 10s
+	start := time.Now()
+	ping := "*1\r\n$4\r\nping\r\n"
+	for i := 0; i < 5; i++ {
+		ts := start.Add(time.Duration(i) * 10 * time.Second)
+		assemble(assembler, tcpPacketAt(t, ts, 5000, 6379, uint32(100+i*len(ping)), false, false, ping))
+		f.flush(ts)
+	}
+	// the first one waits a few seconds for missed data, the rest follow it
+	lines := waitFor(&out, 5)
+	if len(lines) != 5 || lines[4] != "ping" {
+		t.Fatalf("expect 5 pings, got: %q", lines)
+	}
+	assembler.FlushAll()
+	factory.Wait()
+}